@@ -0,0 +1,128 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+/*
+#define _GNU_SOURCE
+#include <dlfcn.h>
+#include <stdint.h>
+
+static int _dladdr(uintptr_t addr, Dl_info *info) {
+	return dladdr((void *)addr, info);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+var (
+	errAddressNotMapped    = errors.New("address is not part of any loaded object")
+	errAddressNotInLibrary = errors.New("address is not part of the library")
+)
+
+// AddressInfo describes a native address by the loaded object
+// (library or executable) containing it and the nearest symbol
+// preceding it, as resolved by dladdr.
+type AddressInfo struct {
+	// Address is the native address which was looked up.
+	Address uintptr
+
+	// Path is the path of the loaded object containing the address.
+	Path string
+
+	// Base is the base address the object is loaded at.
+	Base uintptr
+
+	// Symbol is the name of the nearest symbol with an address lower
+	// or equal to Address, or empty if no symbol could be found.
+	Symbol string
+
+	// SymbolAddress is the address of Symbol, or zero if no symbol
+	// could be found.
+	SymbolAddress uintptr
+
+	// Offset is the distance of Address to SymbolAddress, or to Base
+	// if no symbol could be found.
+	Offset uintptr
+}
+
+// String returns a symbolized representation of the address, in the
+// common backtrace format path(symbol+0xoffset) [0xaddress].
+func (a *AddressInfo) String() string {
+	return fmt.Sprintf("%s(%s+0x%x) [0x%x]", a.Path, a.Symbol, a.Offset, a.Address)
+}
+
+// AddrInfo resolves the given native address, e.g. a function pointer
+// handed over by a C API, to the loaded object containing it and the
+// nearest symbol. An error is returned if the address isn't part of
+// any object loaded into the process.
+func AddrInfo(addr uintptr) (*AddressInfo, error) {
+	var info C.Dl_info
+	if C._dladdr(C.uintptr_t(addr), &info) == 0 {
+		return nil, errAddressNotMapped
+	}
+
+	ai := &AddressInfo{
+		Address: addr,
+		Path:    C.GoString(info.dli_fname),
+		Base:    uintptr(info.dli_fbase),
+		Offset:  addr - uintptr(info.dli_fbase),
+	}
+
+	if info.dli_sname != nil && info.dli_saddr != nil {
+		ai.Symbol = C.GoString(info.dli_sname)
+		ai.SymbolAddress = uintptr(info.dli_saddr)
+		ai.Offset = addr - ai.SymbolAddress
+	}
+	return ai, nil
+}
+
+// AddrInfo resolves the given native address like the package-level
+// AddrInfo, but returns an error if the address is not part of this
+// Library.
+func (l *Library) AddrInfo(addr uintptr) (*AddressInfo, error) {
+	ai, err := AddrInfo(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !samePath(ai.Path, l.name) {
+		return nil, errAddressNotInLibrary
+	}
+	return ai, nil
+}
+
+func samePath(p1, p2 string) bool {
+	if p1 == p2 {
+		return true
+	}
+
+	r1, err := filepath.EvalSymlinks(p1)
+	if err != nil {
+		return false
+	}
+	r2, err := filepath.EvalSymlinks(p2)
+	if err != nil {
+		return false
+	}
+	return r1 == r2
+}
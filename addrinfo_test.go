@@ -0,0 +1,82 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAddrInfoSymbol(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	s, err := l.Symbol("_sqrt")
+	if err != nil {
+		t.Errorf("Symbol _sqrt not available: %v", err)
+		return
+	}
+
+	ai, err := AddrInfo(s + 4)
+	if err != nil {
+		t.Errorf("Address of _sqrt couldn't be resolved: %v", err)
+		return
+	}
+
+	if ai.Symbol != "_sqrt" {
+		t.Errorf("expected symbol '_sqrt', got '%s'", ai.Symbol)
+	}
+	if ai.SymbolAddress != s || ai.Offset != 4 {
+		t.Errorf("expected _sqrt+0x4, got %s", ai)
+	}
+	if !strings.Contains(ai.Path, testLibrary) {
+		t.Errorf("expected path of %s, got '%s'", testLibrary, ai.Path)
+	}
+	if ai.Base == 0 || ai.Base > s {
+		t.Errorf("illegal base address 0x%x for symbol at 0x%x", ai.Base, s)
+	}
+}
+
+func TestLibraryAddrInfoForeignAddress(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	addr := reflect.ValueOf(TestLibraryAddrInfoForeignAddress).Pointer()
+	if _, err := AddrInfo(addr); err != nil {
+		t.Errorf("Address of Go function couldn't be resolved: %v", err)
+	}
+
+	if _, err := l.AddrInfo(addr); err == nil {
+		t.Error("the address of a Go function shouldn't be part of the library")
+	}
+}
+
+func TestAddrInfoUnmapped(t *testing.T) {
+	if _, err := AddrInfo(0); err == nil {
+		t.Error("the address 0 shouldn't be resolvable")
+	}
+}
@@ -0,0 +1,363 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// goffi-bind generates an implementation of a Go interface, whose methods
+// are backed by native functions of a library loaded by libgoffi.
+//
+// It is meant to be used with go generate:
+//
+//	//go:generate goffi-bind -type Reader -prefix foo_ -self
+//	type Reader interface {
+//		Read(buf unsafe.Pointer, n int32) (int32, error)
+//		Close()
+//	}
+//
+// generates a function BindReader(library *goffi.Library, self *goffi.Handle) (Reader, error),
+// which maps Read onto the native function foo_read(foo*, void*, int) and Close onto
+// foo_close(foo*). The symbol name of a method is the given prefix plus the method name
+// in snake case, unless the method is documented with a "goffi:symbol <name>" line.
+// Without -self, the generated function doesn't take the self handle and the native
+// functions are called without the implicit first parameter.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const goffiImportPath = "github.com/clevabit/libgoffi"
+
+var (
+	typeName = flag.String("type", "", "name of the interface type to implement (required)")
+	prefix   = flag.String("prefix", "", "prefix of the native symbol names")
+	self     = flag.Bool("self", false, "pass a self handle as implicit first parameter")
+	output   = flag.String("output", "", "output file name (default <type>_goffi.go)")
+)
+
+// options configure the generated binding
+type options struct {
+	typeName string
+	prefix   string
+	self     bool
+
+	// args are the arguments recorded in the generated header
+	args []string
+}
+
+type method struct {
+	name    string
+	symbol  string
+	params  []*ast.Field
+	results []*ast.Field
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("goffi-bind: ")
+	flag.Parse()
+
+	if *typeName == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	opts := &options{typeName: *typeName, prefix: *prefix, self: *self, args: os.Args[1:]}
+	src, err := generate(dir, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	out := *output
+	if out == "" {
+		out = snakeCase(*typeName) + "_goffi.go"
+	}
+	if err := os.WriteFile(filepath.Join(dir, out), src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func generate(dir string, opts *options) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range pkgs {
+		interfaces := make(map[string]*ast.InterfaceType)
+		files := make(map[string]*ast.File)
+		for _, file := range pkg.Files {
+			ast.Inspect(file, func(n ast.Node) bool {
+				if ts, ok := n.(*ast.TypeSpec); ok {
+					if it, ok := ts.Type.(*ast.InterfaceType); ok {
+						interfaces[ts.Name.Name] = it
+						files[ts.Name.Name] = file
+					}
+				}
+				return true
+			})
+		}
+
+		if _, ok := interfaces[opts.typeName]; !ok {
+			continue
+		}
+
+		methods, err := collectMethods(interfaces, opts.typeName, opts.prefix)
+		if err != nil {
+			return nil, err
+		}
+		return render(fset, pkg.Name, files[opts.typeName], methods, opts)
+	}
+	return nil, fmt.Errorf("interface %s not found in %s", opts.typeName, dir)
+}
+
+func collectMethods(interfaces map[string]*ast.InterfaceType, name, prefix string) ([]*method, error) {
+	methods := make([]*method, 0)
+	for _, field := range interfaces[name].Methods.List {
+		switch t := field.Type.(type) {
+		case *ast.FuncType:
+			methodName := field.Names[0].Name
+			// unexported fields are skipped by ImportAll and would stay nil
+			if !ast.IsExported(methodName) {
+				return nil, fmt.Errorf("method %s is unexported and can't be bound", methodName)
+			}
+			for _, param := range t.Params.List {
+				if _, ok := param.Type.(*ast.Ellipsis); ok {
+					return nil, fmt.Errorf("method %s is variadic, which isn't supported by native imports", methodName)
+				}
+			}
+
+			m := &method{
+				name:   methodName,
+				symbol: prefix + snakeCase(methodName),
+				params: t.Params.List,
+			}
+			if t.Results != nil {
+				m.results = t.Results.List
+			}
+			if symbol := symbolFromDoc(field.Doc); symbol != "" {
+				m.symbol = symbol
+			}
+			methods = append(methods, m)
+
+		case *ast.Ident:
+			if _, ok := interfaces[t.Name]; !ok {
+				return nil, fmt.Errorf("embedded interface %s must be declared in the same package", t.Name)
+			}
+			embedded, err := collectMethods(interfaces, t.Name, prefix)
+			if err != nil {
+				return nil, err
+			}
+			methods = append(methods, embedded...)
+
+		default:
+			return nil, errors.New("embedded interfaces of other packages are not supported")
+		}
+	}
+
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].name < methods[j].name
+	})
+	return methods, nil
+}
+
+func symbolFromDoc(doc *ast.CommentGroup) string {
+	if doc == nil {
+		return ""
+	}
+	for _, line := range strings.Split(doc.Text(), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "goffi:symbol ") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "goffi:symbol "))
+		}
+	}
+	return ""
+}
+
+func render(fset *token.FileSet, pkgName string, file *ast.File, methods []*method, opts *options) ([]byte, error) {
+	typeName := opts.typeName
+	lowerName := string(unicode.ToLower(rune(typeName[0]))) + typeName[1:]
+	funcsType := lowerName + "Funcs"
+	bindingType := lowerName + "Binding"
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by goffi-bind %s; DO NOT EDIT.\n\n", strings.Join(opts.args, " "))
+	fmt.Fprintf(&b, "package %s\n\n", pkgName)
+
+	b.WriteString("import (\n")
+	fmt.Fprintf(&b, "\tgoffi %q\n", goffiImportPath)
+	for _, imp := range usedImports(file, methods) {
+		b.WriteString("\t" + imp + "\n")
+	}
+	b.WriteString(")\n\n")
+
+	fmt.Fprintf(&b, "type %s struct {\n", funcsType)
+	for _, m := range methods {
+		fmt.Fprintf(&b, "\t%s func(%s) %s `goffi:%q`\n", m.name,
+			fieldList(fset, m.params, false), resultList(fset, m.results), m.symbol)
+	}
+	b.WriteString("}\n\n")
+
+	fmt.Fprintf(&b, "type %s struct {\n\tfuncs %s\n}\n\n", bindingType, funcsType)
+
+	selfParam, selfOption := "", ""
+	if opts.self {
+		selfParam, selfOption = ", self *goffi.Handle", ", goffi.Self(self)"
+	}
+
+	fmt.Fprintf(&b, "// Bind%s creates an implementation of %s, whose methods are backed\n", typeName, typeName)
+	b.WriteString("// by the native functions of the given library.\n")
+	fmt.Fprintf(&b, "func Bind%s(library *goffi.Library%s) (%s, error) {\n", typeName, selfParam, typeName)
+	fmt.Fprintf(&b, "\tb := &%s{}\n", bindingType)
+	fmt.Fprintf(&b, "\tif err := library.ImportAll(&b.funcs%s); err != nil {\n", selfOption)
+	b.WriteString("\t\treturn nil, err\n\t}\n\treturn b, nil\n}\n")
+
+	for _, m := range methods {
+		fmt.Fprintf(&b, "\nfunc (b *%s) %s(%s) %s {\n", bindingType, m.name,
+			fieldList(fset, m.params, true), resultList(fset, m.results))
+		call := fmt.Sprintf("b.funcs.%s(%s)", m.name, argumentList(m.params))
+		if len(m.results) > 0 {
+			b.WriteString("\treturn " + call + "\n}\n")
+		} else {
+			b.WriteString("\t" + call + "\n}\n")
+		}
+	}
+
+	return format.Source(b.Bytes())
+}
+
+func fieldList(fset *token.FileSet, fields []*ast.Field, named bool) string {
+	parts := make([]string, 0)
+	index := 0
+	for _, field := range fields {
+		typ := nodeString(fset, field.Type)
+		count := len(field.Names)
+		if count == 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			if named {
+				parts = append(parts, "p"+strconv.Itoa(index)+" "+typ)
+			} else {
+				parts = append(parts, typ)
+			}
+			index++
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func resultList(fset *token.FileSet, fields []*ast.Field) string {
+	list := fieldList(fset, fields, false)
+	if strings.Contains(list, ",") {
+		return "(" + list + ")"
+	}
+	return list
+}
+
+func argumentList(fields []*ast.Field) string {
+	args := make([]string, 0)
+	index := 0
+	for _, field := range fields {
+		count := len(field.Names)
+		if count == 0 {
+			count = 1
+		}
+		for i := 0; i < count; i++ {
+			args = append(args, "p"+strconv.Itoa(index))
+			index++
+		}
+	}
+	return strings.Join(args, ", ")
+}
+
+func nodeString(fset *token.FileSet, node ast.Node) string {
+	var b bytes.Buffer
+	printer.Fprint(&b, fset, node)
+	return b.String()
+}
+
+func usedImports(file *ast.File, methods []*method) []string {
+	used := make(map[string]bool)
+	for _, m := range methods {
+		for _, fields := range [][]*ast.Field{m.params, m.results} {
+			for _, field := range fields {
+				ast.Inspect(field.Type, func(n ast.Node) bool {
+					if sel, ok := n.(*ast.SelectorExpr); ok {
+						if ident, ok := sel.X.(*ast.Ident); ok {
+							used[ident.Name] = true
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+
+	imports := make([]string, 0)
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if used[name] {
+			imports = append(imports, spec.Path.Value)
+			if spec.Name != nil {
+				imports[len(imports)-1] = spec.Name.Name + " " + spec.Path.Value
+			}
+		}
+	}
+	return imports
+}
+
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func TestGenerate(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opts *options
+	}{
+		{"reader", &options{typeName: "Reader", prefix: "foo_", self: true, args: []string{"-type", "Reader", "-prefix", "foo_", "-self"}}},
+		{"reader_noself", &options{typeName: "Reader", prefix: "bar_", args: []string{"-type", "Reader", "-prefix", "bar_"}}},
+	} {
+		src, err := generate(filepath.Join("testdata", "reader"), test.opts)
+		if err != nil {
+			t.Errorf("%s: generation failed: %v", test.name, err)
+			continue
+		}
+
+		golden := filepath.Join("testdata", test.name+".golden")
+		if *update {
+			if err := os.WriteFile(golden, src, 0644); err != nil {
+				t.Errorf("%s: failed to update golden file: %v", test.name, err)
+			}
+			continue
+		}
+
+		expected, err := os.ReadFile(golden)
+		if err != nil {
+			t.Errorf("%s: failed to read golden file: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(src, expected) {
+			t.Errorf("%s: generated code doesn't match %s:\n%s", test.name, golden, src)
+		}
+	}
+}
+
+func TestGenerateIllegalMethods(t *testing.T) {
+	for _, test := range []struct {
+		dir, typeName, message string
+	}{
+		{"unexported", "Reader", "method reset is unexported"},
+		{"variadic", "Printer", "method Printf is variadic"},
+		{"reader", "Writer", "interface Writer not found"},
+	} {
+		_, err := generate(filepath.Join("testdata", test.dir), &options{typeName: test.typeName})
+		if err == nil || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected error containing %q, got %v", test.dir, test.message, err)
+		}
+	}
+}
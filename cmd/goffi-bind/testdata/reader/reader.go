@@ -0,0 +1,18 @@
+package reader
+
+import (
+	"unsafe"
+)
+
+type Closer interface {
+	Close()
+}
+
+type Reader interface {
+	Closer
+
+	Read(buf unsafe.Pointer, n int32) (int32, error)
+
+	// goffi:symbol foo_bytes_available
+	Available() int64
+}
@@ -0,0 +1,6 @@
+package unexported
+
+type Reader interface {
+	Read(n int32) int32
+	reset()
+}
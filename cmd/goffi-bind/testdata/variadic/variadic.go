@@ -0,0 +1,5 @@
+package variadic
+
+type Printer interface {
+	Printf(format string, args ...int32) int32
+}
@@ -0,0 +1,94 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+)
+
+// ConcurrencyPolicy defines how many calls to functions of a Library
+// may be executed concurrently.
+type ConcurrencyPolicy int
+
+const (
+	// Unrestricted doesn't restrict concurrent calls, which is the
+	// default for a newly loaded Library.
+	Unrestricted ConcurrencyPolicy = 0
+
+	// Serialized executes only one call to the Library at a time,
+	// for libraries which aren't thread-safe.
+	Serialized ConcurrencyPolicy = 1
+)
+
+// Bounded executes at most n calls to the Library at a time. Values
+// smaller than 1 are treated as Unrestricted.
+func Bounded(n int) ConcurrencyPolicy {
+	if n < 1 {
+		return Unrestricted
+	}
+	return ConcurrencyPolicy(n)
+}
+
+func (p ConcurrencyPolicy) String() string {
+	switch {
+	case p < 1:
+		return "unrestricted"
+	case p == Serialized:
+		return "serialized"
+	}
+	return "bounded(" + strconv.Itoa(int(p)) + ")"
+}
+
+// SetConcurrencyPolicy sets the concurrency policy of the Library, which is
+// enforced by all functions imported from it, including already imported
+// functions. Calls exceeding the policy block until a running call returns.
+// Calls already running when the policy is changed are not affected.
+// Attention: A native function must not call back into an imported function
+// of a serialized Library, since this would deadlock.
+func (l *Library) SetConcurrencyPolicy(policy ConcurrencyPolicy) {
+	var semaphore chan struct{}
+	if policy >= Serialized {
+		semaphore = make(chan struct{}, int(policy))
+	}
+	l.semaphore.Store(semaphore)
+}
+
+// ConcurrencyPolicy returns the currently active concurrency policy.
+func (l *Library) ConcurrencyPolicy() ConcurrencyPolicy {
+	return ConcurrencyPolicy(cap(l.semaphore.Load().(chan struct{})))
+}
+
+func (l *Library) concurrencyStub(sig *signature, call callFunc) callFunc {
+	return func(ctx context.Context, values []reflect.Value) []reflect.Value {
+		semaphore := l.semaphore.Load().(chan struct{})
+		if semaphore == nil {
+			return call(ctx, values)
+		}
+
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			return sig.fail(ctx.Err())
+		}
+		defer func() {
+			<-semaphore
+		}()
+		return call(ctx, values)
+	}
+}
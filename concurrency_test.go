@@ -0,0 +1,74 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrencyPolicy(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var call func(int32) int32
+	if err := l.Import("_concurrent_call", &call); err != nil {
+		t.Errorf("Symbol _concurrent_call failed to be imported: %v", err)
+		return
+	}
+
+	var reset func() int32
+	if err := l.Import("_concurrent_max_reset", &reset); err != nil {
+		t.Errorf("Symbol _concurrent_max_reset failed to be imported: %v", err)
+		return
+	}
+
+	run := func() int32 {
+		reset()
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				call(5)
+			}()
+		}
+		wg.Wait()
+		return reset()
+	}
+
+	if p := l.ConcurrencyPolicy(); p != Unrestricted {
+		t.Errorf("expected unrestricted policy, got %s", p)
+	}
+
+	l.SetConcurrencyPolicy(Serialized)
+	if max := run(); max != 1 {
+		t.Errorf("expected serialized calls, got %d concurrent calls", max)
+	}
+
+	l.SetConcurrencyPolicy(Bounded(3))
+	if max := run(); max > 3 {
+		t.Errorf("expected at most 3 concurrent calls, got %d", max)
+	}
+	if p := l.ConcurrencyPolicy(); p.String() != "bounded(3)" {
+		t.Errorf("expected bounded(3) policy, got %s", p)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+/*
+#include <pthread.h>
+#include <signal.h>
+
+static int _pthread_kill(pthread_t thread, int sig) {
+	return pthread_kill(thread, sig);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+// TypeContext represents the Go context.Context interface. A function
+// taking a context.Context as its first parameter is imported as a
+// cancellable function (see Import), the context itself is not passed
+// to the C function.
+var TypeContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+var errContextWithoutError = errors.New("functions taking a context must return an error")
+
+// OnCancel registers a function, which is called when the context of a
+// running call to a cancellable function is cancelled or exceeds its deadline.
+// The function is expected to unblock the native call, e.g. by calling a
+// cancellation function of the library.
+func OnCancel(fn func()) ImportOption {
+	return func(config *importConfig) {
+		config.cancel = fn
+	}
+}
+
+// CancelSymbol registers the given symbol as the cancellation function of a
+// cancellable function (see OnCancel). The symbol must be a function of the
+// same library without parameters, and its result is ignored. It is called
+// directly from the cancelling goroutine, regardless of the thread and the
+// concurrency policy of the Library.
+func CancelSymbol(symbol string) ImportOption {
+	return func(config *importConfig) {
+		config.cancelSymbol = symbol
+	}
+}
+
+// CancelSignal sends the given signal to the thread executing the native call,
+// when the context of a running call to a cancellable function is cancelled or
+// exceeds its deadline. This interrupts blocking system calls, which fail with
+// EINTR, if the signal is handled without restarting them.
+// Attention: The signal must be handled, either by the library or using
+// signal.Notify, otherwise the default action of the signal applies.
+func CancelSignal(signal syscall.Signal) ImportOption {
+	return func(config *importConfig) {
+		config.cancelSignal = signal
+	}
+}
+
+type callStateKey struct{}
+
+// callState tracks the native call of a cancellable function
+type callState struct {
+	m         sync.Mutex
+	running   bool
+	cancelled bool
+	thread    C.pthread_t
+}
+
+func (s *callState) enter() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.cancelled {
+		return false
+	}
+
+	// the thread must not change until the native call returned
+	runtime.LockOSThread()
+	s.running = true
+	s.thread = C.pthread_self()
+	return true
+}
+
+func (s *callState) exit() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.running = false
+	runtime.UnlockOSThread()
+}
+
+func (s *callState) cancel(fn func(), signal syscall.Signal) {
+	s.m.Lock()
+	s.cancelled = true
+	running := s.running
+	if running && signal != 0 {
+		C._pthread_kill(s.thread, C.int(signal))
+	}
+	s.m.Unlock()
+
+	if running && fn != nil {
+		fn()
+	}
+}
+
+type callResult struct {
+	results  []reflect.Value
+	panicked bool
+	panic    interface{}
+}
+
+func nativeCall(sig *signature, stub stubFunc) callFunc {
+	return func(ctx context.Context, values []reflect.Value) []reflect.Value {
+		state, ok := ctx.Value(callStateKey{}).(*callState)
+		if !ok {
+			return stub(values)
+		}
+
+		if !state.enter() {
+			return sig.fail(ctx.Err())
+		}
+		defer state.exit()
+		return stub(values)
+	}
+}
+
+func (l *Library) contextStub(sig *signature, call callFunc, config *importConfig) (stubFunc, error) {
+	cancel := config.cancel
+	if config.cancelSymbol != "" {
+		fn, err := l.importCancel(config.cancelSymbol)
+		if err != nil {
+			return nil, err
+		}
+		cancel = fn
+	}
+
+	return func(values []reflect.Value) []reflect.Value {
+		ctx, _ := values[0].Interface().(context.Context)
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		values = values[1:]
+
+		if err := ctx.Err(); err != nil {
+			return sig.fail(err)
+		}
+		if ctx.Done() == nil {
+			return call(ctx, values)
+		}
+
+		state := &callState{}
+		ctx = context.WithValue(ctx, callStateKey{}, state)
+
+		// the call is executed on a separate goroutine, to return as soon
+		// as the context is done, even if the native call is still blocked
+		done := make(chan callResult, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					done <- callResult{panicked: true, panic: p}
+				}
+			}()
+			done <- callResult{results: call(ctx, values)}
+		}()
+
+		select {
+		case result := <-done:
+			if result.panicked {
+				panic(result.panic)
+			}
+			return result.results
+		case <-ctx.Done():
+			state.cancel(cancel, config.cancelSignal)
+			return sig.fail(ctx.Err())
+		}
+	}, nil
+}
+
+// importCancel binds the cancellation function of a cancellable function.
+// The function is called directly, since it must not wait for a dedicated
+// thread or the concurrency policy, which are still occupied by the blocked
+// call it is meant to unblock.
+func (l *Library) importCancel(symbol string) (func(), error) {
+	fnType := reflect.TypeOf(func() {})
+	sig, err := newSignature(fnType, fnType, false, &importConfig{})
+	if err != nil {
+		return nil, err
+	}
+	sig.symbol = symbol
+
+	outType := wrapReturnType(fnType)
+	cif, err := l.getOrCreateCif(symbol, outType, fnType)
+	if err != nil {
+		return nil, err
+	}
+
+	funcPtr, err := l.makeFunctionPointer(symbol)
+	if err != nil {
+		return nil, err
+	}
+
+	stub := makeStub(sig, cif, funcPtr, outType)
+	return func() {
+		stub(nil)
+	}, nil
+}
+
+// contextFnType returns the function type without a leading context parameter,
+// and whether the function takes a context
+func contextFnType(fnType reflect.Type) (reflect.Type, bool) {
+	if fnType.NumIn() == 0 || fnType.In(0) != TypeContext {
+		return fnType, false
+	}
+
+	in := make([]reflect.Type, fnType.NumIn()-1)
+	for i := range in {
+		in[i] = fnType.In(i + 1)
+	}
+
+	out := make([]reflect.Type, fnType.NumOut())
+	for i := range out {
+		out[i] = fnType.Out(i)
+	}
+	return reflect.FuncOf(in, out, fnType.IsVariadic()), true
+}
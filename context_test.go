@@ -0,0 +1,165 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestContextCall(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var sint32 func(context.Context, int32) (int32, error)
+	if err := l.Import("__sint32", &sint32); err != nil {
+		t.Errorf("Symbol __sint32 failed to be imported: %v", err)
+		return
+	}
+
+	if v, err := sint32(context.Background(), 63); err != nil || v != 31 {
+		t.Errorf("expected 31, got %d (%v)", v, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if v, err := sint32(ctx, 63); err != nil || v != 31 {
+		t.Errorf("expected 31, got %d (%v)", v, err)
+	}
+
+	cancel()
+	if _, err := sint32(ctx, 63); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	var noError func(context.Context, int32) int32
+	if err := l.Import("__sint32", &noError); err != errContextWithoutError {
+		t.Errorf("expected errContextWithoutError, got %v", err)
+	}
+}
+
+func TestContextCancelSymbol(t *testing.T) {
+	testContextCancelSymbol(t, func(l *Library) {})
+}
+
+func TestContextCancelSymbolSerialized(t *testing.T) {
+	testContextCancelSymbol(t, func(l *Library) {
+		l.SetConcurrencyPolicy(Serialized)
+	})
+}
+
+func TestContextCancelSymbolOnThread(t *testing.T) {
+	thread := NewThread()
+	defer thread.Close()
+
+	testContextCancelSymbol(t, func(l *Library) {
+		l.SetThread(thread)
+	})
+}
+
+// testContextCancelSymbol checks, that the cancellation function isn't
+// blocked by the call it is meant to unblock
+func testContextCancelSymbol(t *testing.T, configure func(l *Library)) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+	configure(l)
+
+	var wait func(context.Context, int32) (int32, error)
+	if err := l.Import("_blocking_wait", &wait, CancelSymbol("_blocking_cancel")); err != nil {
+		t.Errorf("Symbol _blocking_wait failed to be imported: %v", err)
+		return
+	}
+
+	var returned func() int32
+	if err := l.Import("_blocking_returned", &returned); err != nil {
+		t.Errorf("Symbol _blocking_returned failed to be imported: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := wait(ctx, 10000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("call returned after %s", d)
+	}
+
+	if !eventually(func() bool { return returned() == 1 }) {
+		t.Error("native call wasn't cancelled")
+	}
+}
+
+func TestContextCancelSignal(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	defer signal.Stop(signals)
+
+	var sleep func(context.Context, int32) (int32, error)
+	if err := l.Import("_interruptible_sleep", &sleep, CancelSignal(syscall.SIGUSR1)); err != nil {
+		t.Errorf("Symbol _interruptible_sleep failed to be imported: %v", err)
+		return
+	}
+
+	var interrupted func() int32
+	if err := l.Import("_sleep_interrupted", &interrupted); err != nil {
+		t.Errorf("Symbol _sleep_interrupted failed to be imported: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := sleep(ctx, 10000); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if !eventually(func() bool { return interrupted() == 1 }) {
+		t.Error("native call wasn't interrupted")
+	}
+}
+
+func eventually(condition func() bool) bool {
+	for i := 0; i < 500; i++ {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
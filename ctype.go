@@ -0,0 +1,842 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+/*
+#include <ffi.h>
+#include <limits.h>
+#include <stddef.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <sys/types.h>
+
+#define _alignof(T) offsetof(struct { char c; T t; }, t)
+
+const int _sizeShort = sizeof(short);
+const int _sizeInt = sizeof(int);
+const int _sizeLong = sizeof(long);
+const int _sizeLongLong = sizeof(long long);
+const int _sizeSizeT = sizeof(size_t);
+const int _sizePointer = sizeof(void *);
+const int _charSigned = CHAR_MIN < 0;
+
+const int _alignInt8 = _alignof(int8_t);
+const int _alignInt16 = _alignof(int16_t);
+const int _alignInt32 = _alignof(int32_t);
+const int _alignInt64 = _alignof(int64_t);
+const int _alignFloat = _alignof(float);
+const int _alignDouble = _alignof(double);
+const int _alignPointer = _alignof(void *);
+const int _alignBool = _alignof(_Bool);
+
+static ffi_type *_newStructType(int nelements) {
+	ffi_type *t = (ffi_type *)calloc(1, sizeof(ffi_type));
+	t->type = FFI_TYPE_STRUCT;
+	t->elements = (ffi_type **)calloc(nelements + 1, sizeof(ffi_type *));
+	return t;
+}
+
+static void _structTypeSet(ffi_type *t, int index, ffi_type *element) {
+	t->elements[index] = element;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+var (
+	errEmptyAggregate     = errors.New("structs and unions need at least one field")
+	errArrayByValue       = errors.New("arrays can't be passed by value, use a pointer instead")
+	errVoidField          = errors.New("void is not a legal field type")
+	errUnexportedField    = errors.New("struct fields must be exported")
+	errIllegalArrayLength = errors.New("array length must be positive")
+)
+
+// CKind represents the specific kind of C type a CType describes.
+type CKind int
+
+const (
+	// CKindVoid is the kind of the C void type.
+	CKindVoid CKind = iota
+
+	// CKindSigned is the kind of signed C integer types.
+	CKindSigned
+
+	// CKindUnsigned is the kind of unsigned C integer types.
+	CKindUnsigned
+
+	// CKindFloat is the kind of C floating point types.
+	CKindFloat
+
+	// CKindBool is the kind of the C _Bool type.
+	CKindBool
+
+	// CKindPointer is the kind of C pointer types.
+	CKindPointer
+
+	// CKindString is the kind of C strings (char*), which are
+	// mapped to Go strings.
+	CKindString
+
+	// CKindStruct is the kind of C structs.
+	CKindStruct
+
+	// CKindUnion is the kind of C unions.
+	CKindUnion
+
+	// CKindArray is the kind of fixed-size C arrays.
+	CKindArray
+)
+
+var ckindNames = []string{"void", "signed", "unsigned", "float", "bool", "pointer", "string", "struct", "union", "array"}
+
+func (k CKind) String() string {
+	if int(k) < len(ckindNames) {
+		return ckindNames[k]
+	}
+	return "kind" + strconv.Itoa(int(k))
+}
+
+// CType describes a C data type, including its size and alignment.
+// Primitive types are predefined (e.g. CTypeInt32 or CTypeDouble),
+// composed types are created using PointerTo, StructOf, UnionOf
+// and ArrayOf.
+// CTypes can be used to define the C side of an imported function
+// (see CFuncOf), and can be printed as a C declaration for debugging.
+type CType struct {
+	kind   CKind
+	name   string
+	size   uintptr
+	align  uintptr
+	elem   *CType
+	length int
+	fields []CField
+	ffi    ffiType
+	goType reflect.Type
+}
+
+// CField describes a field of a C struct or union.
+type CField struct {
+	// Name is the name of the field.
+	Name string
+
+	// Type is the C type of the field.
+	Type *CType
+
+	// Offset is the offset of the field inside the struct. It is
+	// calculated when creating the struct and ignored as an input.
+	Offset uintptr
+}
+
+var (
+	// CTypeVoid describes the C void type.
+	CTypeVoid = &CType{kind: CKindVoid, name: "void", ffi: typeVoid}
+
+	// CTypeBool describes the C _Bool type.
+	CTypeBool = newPrimitive(CKindBool, "_Bool", uintptr(boolSize), uintptr(C._alignBool))
+
+	// CTypeInt8 describes the C int8_t type.
+	CTypeInt8 = newPrimitive(CKindSigned, "int8_t", 1, uintptr(C._alignInt8))
+
+	// CTypeInt16 describes the C int16_t type.
+	CTypeInt16 = newPrimitive(CKindSigned, "int16_t", 2, uintptr(C._alignInt16))
+
+	// CTypeInt32 describes the C int32_t type.
+	CTypeInt32 = newPrimitive(CKindSigned, "int32_t", 4, uintptr(C._alignInt32))
+
+	// CTypeInt64 describes the C int64_t type.
+	CTypeInt64 = newPrimitive(CKindSigned, "int64_t", 8, uintptr(C._alignInt64))
+
+	// CTypeUint8 describes the C uint8_t type.
+	CTypeUint8 = newPrimitive(CKindUnsigned, "uint8_t", 1, uintptr(C._alignInt8))
+
+	// CTypeUint16 describes the C uint16_t type.
+	CTypeUint16 = newPrimitive(CKindUnsigned, "uint16_t", 2, uintptr(C._alignInt16))
+
+	// CTypeUint32 describes the C uint32_t type.
+	CTypeUint32 = newPrimitive(CKindUnsigned, "uint32_t", 4, uintptr(C._alignInt32))
+
+	// CTypeUint64 describes the C uint64_t type.
+	CTypeUint64 = newPrimitive(CKindUnsigned, "uint64_t", 8, uintptr(C._alignInt64))
+
+	// CTypeChar describes the C char type, which is signed or unsigned
+	// depending on the platform.
+	CTypeChar = newInteger("char", 1, C._charSigned != 0)
+
+	// CTypeShort describes the C short type.
+	CTypeShort = newInteger("short", uintptr(C._sizeShort), true)
+
+	// CTypeUShort describes the C unsigned short type.
+	CTypeUShort = newInteger("unsigned short", uintptr(C._sizeShort), false)
+
+	// CTypeInt describes the C int type.
+	CTypeInt = newInteger("int", uintptr(C._sizeInt), true)
+
+	// CTypeUInt describes the C unsigned int type.
+	CTypeUInt = newInteger("unsigned int", uintptr(C._sizeInt), false)
+
+	// CTypeLong describes the C long type.
+	CTypeLong = newInteger("long", uintptr(C._sizeLong), true)
+
+	// CTypeULong describes the C unsigned long type.
+	CTypeULong = newInteger("unsigned long", uintptr(C._sizeLong), false)
+
+	// CTypeLongLong describes the C long long type.
+	CTypeLongLong = newInteger("long long", uintptr(C._sizeLongLong), true)
+
+	// CTypeULongLong describes the C unsigned long long type.
+	CTypeULongLong = newInteger("unsigned long long", uintptr(C._sizeLongLong), false)
+
+	// CTypeSizeT describes the C size_t type.
+	CTypeSizeT = newInteger("size_t", uintptr(C._sizeSizeT), false)
+
+	// CTypeSSizeT describes the C ssize_t type.
+	CTypeSSizeT = newInteger("ssize_t", uintptr(C._sizeSizeT), true)
+
+	// CTypeFloat describes the C float type.
+	CTypeFloat = newPrimitive(CKindFloat, "float", 4, uintptr(C._alignFloat))
+
+	// CTypeDouble describes the C double type.
+	CTypeDouble = newPrimitive(CKindFloat, "double", 8, uintptr(C._alignDouble))
+
+	// CTypePointer describes the C void* type.
+	CTypePointer = PointerTo(CTypeVoid)
+
+	// CTypeString describes the C char* type, mapped to a Go string.
+	CTypeString = &CType{kind: CKindString, name: "char *", size: uintptr(C._sizePointer),
+		align: uintptr(C._alignPointer), elem: CTypeChar, ffi: typePointer, goType: TypeString}
+)
+
+var ctypes = struct {
+	sync.RWMutex
+	byGoType  map[reflect.Type]*CType
+	byRepr    map[reflect.Type]*CType
+	byFfiType map[ffiType]*CType
+}{
+	byGoType:  make(map[reflect.Type]*CType),
+	byRepr:    make(map[reflect.Type]*CType),
+	byFfiType: make(map[ffiType]*CType),
+}
+
+func newPrimitive(kind CKind, name string, size, align uintptr) *CType {
+	t := &CType{kind: kind, name: name, size: size, align: align}
+	switch kind {
+	case CKindFloat:
+		t.ffi, t.goType = typeFloat, TypeFloat32
+		if size == 8 {
+			t.ffi, t.goType = typeDouble, TypeFloat64
+		}
+	case CKindBool:
+		t.ffi, _ = integerType(int(size), true)
+		t.goType = TypeBool
+	default:
+		t.ffi, t.goType = integerType(int(size), kind == CKindSigned)
+	}
+	return t
+}
+
+func newInteger(name string, size uintptr, signed bool) *CType {
+	kind := CKindUnsigned
+	if signed {
+		kind = CKindSigned
+	}
+
+	align := uintptr(C._alignInt8)
+	switch size {
+	case 2:
+		align = uintptr(C._alignInt16)
+	case 4:
+		align = uintptr(C._alignInt32)
+	case 8:
+		align = uintptr(C._alignInt64)
+	}
+	return newPrimitive(kind, name, size, align)
+}
+
+func integerType(size int, signed bool) (ffiType, reflect.Type) {
+	switch size {
+	case 1:
+		if signed {
+			return typeInt8, TypeInt8
+		}
+		return typeUint8, TypeUint8
+	case 2:
+		if signed {
+			return typeInt16, TypeInt16
+		}
+		return typeUint16, TypeUint16
+	case 4:
+		if signed {
+			return typeInt32, TypeInt32
+		}
+		return typeUint32, TypeUint32
+	case 8:
+		if signed {
+			return typeInt64, TypeInt64
+		}
+		return typeUint64, TypeUint64
+	}
+	panic(fmt.Errorf("unsupported integer size: %d", size))
+}
+
+// PointerTo returns the C type describing a pointer to the given type.
+// Pointers are mapped to uintptr values on the Go side.
+func PointerTo(elem *CType) *CType {
+	return &CType{
+		kind:   CKindPointer,
+		size:   uintptr(C._sizePointer),
+		align:  uintptr(C._alignPointer),
+		elem:   elem,
+		ffi:    typePointer,
+		goType: TypeUintptr,
+	}
+}
+
+// ArrayOf returns the C type describing a fixed-size array of the given
+// length and element type. Arrays can only be used as struct or union
+// fields, since C doesn't pass arrays by value.
+func ArrayOf(elem *CType, length int) (*CType, error) {
+	if length <= 0 {
+		return nil, errIllegalArrayLength
+	}
+	if elem.kind == CKindVoid {
+		return nil, errVoidField
+	}
+
+	t := &CType{
+		kind:   CKindArray,
+		size:   elem.size * uintptr(length),
+		align:  elem.align,
+		elem:   elem,
+		length: length,
+		goType: reflect.ArrayOf(length, elem.fieldType()),
+	}
+
+	t.ffi = newStructFfiType(length, func(i int) ffiType {
+		return elem.ffi
+	})
+	return t, nil
+}
+
+// StructOf returns the C type describing a struct of the given fields. The
+// name is used when printing the type and may be empty for anonymous structs.
+// Field offsets, size and alignment are calculated using the standard C
+// layout rules of the platform.
+// Structs are mapped to Go structs with the same number of (exported) fields,
+// which are converted field by field.
+func StructOf(name string, fields ...CField) (*CType, error) {
+	if len(fields) == 0 {
+		return nil, errEmptyAggregate
+	}
+
+	t := &CType{kind: CKindStruct, name: name, align: 1}
+	t.fields = make([]CField, len(fields))
+
+	offset := uintptr(0)
+	for i, f := range fields {
+		if f.Type == nil || f.Type.kind == CKindVoid {
+			return nil, errVoidField
+		}
+
+		offset = alignUp(offset, f.Type.align)
+		t.fields[i] = CField{Name: f.Name, Type: f.Type, Offset: offset}
+		offset += f.Type.size
+		if f.Type.align > t.align {
+			t.align = f.Type.align
+		}
+	}
+	t.size = alignUp(offset, t.align)
+
+	goFields := make([]reflect.StructField, len(fields))
+	for i, f := range t.fields {
+		goFields[i] = reflect.StructField{Name: "F" + strconv.Itoa(i), Type: f.Type.fieldType()}
+	}
+
+	t.goType = reflect.StructOf(goFields)
+	for i, f := range t.fields {
+		if t.goType.Field(i).Offset != f.Offset {
+			return nil, fmt.Errorf("unsupported layout of field %s in %s", f.Name, t)
+		}
+	}
+	if t.goType.Size() != t.size {
+		return nil, fmt.Errorf("unsupported layout of %s", t)
+	}
+
+	t.ffi = newStructFfiType(len(fields), func(i int) ffiType {
+		return t.fields[i].Type.ffi
+	})
+	registerCType(t)
+	return t, nil
+}
+
+// UnionOf returns the C type describing a union of the given fields. The
+// name is used when printing the type and may be empty for anonymous unions.
+// Unions are mapped to Go values, which are copied bytewise and must not
+// be bigger than the union itself.
+// Attention: libffi doesn't support unions, they are passed as a struct of
+// the same size and alignment. Depending on the ABI, this may be wrong for
+// unions containing floating point fields.
+func UnionOf(name string, fields ...CField) (*CType, error) {
+	if len(fields) == 0 {
+		return nil, errEmptyAggregate
+	}
+
+	t := &CType{kind: CKindUnion, name: name, align: 1}
+	t.fields = make([]CField, len(fields))
+	for i, f := range fields {
+		if f.Type == nil || f.Type.kind == CKindVoid {
+			return nil, errVoidField
+		}
+
+		t.fields[i] = CField{Name: f.Name, Type: f.Type}
+		if f.Type.size > t.size {
+			t.size = f.Type.size
+		}
+		if f.Type.align > t.align {
+			t.align = f.Type.align
+		}
+	}
+	t.size = alignUp(t.size, t.align)
+
+	elemFfi, elemType := integerType(int(t.align), false)
+	t.goType = reflect.StructOf([]reflect.StructField{
+		{Name: "F0", Type: reflect.ArrayOf(int(t.size/t.align), elemType)},
+	})
+	if t.goType.Align() != int(t.align) {
+		return nil, fmt.Errorf("unsupported layout of %s", t)
+	}
+
+	t.ffi = newStructFfiType(int(t.size/t.align), func(i int) ffiType {
+		return elemFfi
+	})
+	registerCType(t)
+	return t, nil
+}
+
+// CTypeOf derives the C type of the given Go type, as used by the automatic
+// type mapping. Go structs are mapped to C structs of their (exported) fields,
+// Go arrays to C arrays. String, pointer and slice fields are mapped to C
+// pointers, the referenced Go memory is marshalled when passed to C.
+func CTypeOf(t reflect.Type) (*CType, error) {
+	return cTypeOf(t, make(map[reflect.Type]bool))
+}
+
+// cTypeOf derives the C type of the given Go type, building contains the
+// struct types currently derived, which may be referenced recursively
+func cTypeOf(t reflect.Type, building map[reflect.Type]bool) (*CType, error) {
+	ctypes.RLock()
+	ct := ctypes.byGoType[t]
+	ctypes.RUnlock()
+	if ct != nil {
+		return ct, nil
+	}
+
+	if def := lookupEnum(t); def != nil {
+		k := def.repr.Kind()
+		return newInteger(t.Name(), def.repr.Size(), k >= reflect.Int && k <= reflect.Int64), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return CTypeBool, nil
+	case reflect.Int:
+		return CTypeInt, nil
+	case reflect.Int8:
+		return CTypeInt8, nil
+	case reflect.Int16:
+		return CTypeInt16, nil
+	case reflect.Int32:
+		return CTypeInt32, nil
+	case reflect.Int64:
+		return CTypeInt64, nil
+	case reflect.Uint:
+		return CTypeUInt, nil
+	case reflect.Uint8:
+		return CTypeUint8, nil
+	case reflect.Uint16:
+		return CTypeUint16, nil
+	case reflect.Uint32:
+		return CTypeUint32, nil
+	case reflect.Uint64:
+		return CTypeUint64, nil
+	case reflect.Float32:
+		return CTypeFloat, nil
+	case reflect.Float64:
+		return CTypeDouble, nil
+	case reflect.String:
+		return CTypeString, nil
+	case reflect.Ptr, reflect.Slice, reflect.UnsafePointer, reflect.Uintptr:
+		return CTypePointer, nil
+
+	case reflect.Array:
+		elem, err := cTypeOf(t.Elem(), building)
+		if err != nil {
+			return nil, err
+		}
+
+		ct, err := ArrayOf(elem, t.Len())
+		if err != nil {
+			return nil, err
+		}
+		return cacheCType(t, ct), nil
+
+	case reflect.Struct:
+		building[t] = true
+		defer delete(building, t)
+
+		fields := make([]CField, t.NumField())
+		for i := range fields {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				return nil, errUnexportedField
+			}
+
+			ft, err := cFieldTypeOf(f.Type, building)
+			if err != nil {
+				return nil, err
+			}
+			fields[i] = CField{Name: f.Name, Type: ft}
+		}
+
+		ct, err := StructOf(t.Name(), fields...)
+		if err != nil {
+			return nil, err
+		}
+		return cacheCType(t, ct), nil
+	}
+	return nil, fmt.Errorf("unhandled data type: %s", t.Kind().String())
+}
+
+// cacheCType stores the derived C type of the Go type, since aggregate types
+// allocate their libffi type description. If the type was derived concurrently,
+// the already cached C type is returned.
+func cacheCType(t reflect.Type, ct *CType) *CType {
+	ctypes.Lock()
+	defer ctypes.Unlock()
+	if cached := ctypes.byGoType[t]; cached != nil {
+		return cached
+	}
+	ctypes.byGoType[t] = ct
+	return ct
+}
+
+// cFieldTypeOf derives the C type of a struct field. Pointers and slices
+// are mapped to pointers to their element type.
+func cFieldTypeOf(t reflect.Type, building map[reflect.Type]bool) (*CType, error) {
+	if t.Kind() != reflect.Ptr && t.Kind() != reflect.Slice {
+		return cTypeOf(t, building)
+	}
+
+	// recursive types, such as linked lists, are referenced as void pointers
+	if building[t.Elem()] {
+		return CTypePointer, nil
+	}
+
+	elem, err := cFieldTypeOf(t.Elem(), building)
+	if err != nil {
+		return nil, err
+	}
+	return PointerTo(elem), nil
+}
+
+// Kind returns the specific kind of the C type.
+func (t *CType) Kind() CKind {
+	return t.kind
+}
+
+// Size returns the size of the C type in bytes.
+func (t *CType) Size() uintptr {
+	return t.size
+}
+
+// Align returns the alignment of the C type in bytes.
+func (t *CType) Align() uintptr {
+	return t.align
+}
+
+// Elem returns the element type of pointer and array types, or nil.
+func (t *CType) Elem() *CType {
+	return t.elem
+}
+
+// Len returns the length of array types, or 0.
+func (t *CType) Len() int {
+	return t.length
+}
+
+// Fields returns the fields of struct and union types, or nil.
+func (t *CType) Fields() []CField {
+	return append([]CField(nil), t.fields...)
+}
+
+// GoType returns the Go type, the C type is represented by on the Go side.
+// For structs and unions this is a generated struct type matching the C
+// memory layout.
+func (t *CType) GoType() reflect.Type {
+	return t.goType
+}
+
+// fieldType returns the Go type used to represent the C type inside
+// of struct and array representations
+func (t *CType) fieldType() reflect.Type {
+	if t.kind == CKindString {
+		return TypeUintptr
+	}
+	return t.goType
+}
+
+// String returns the C type name, such as "int32_t", "struct point" or "double *".
+func (t *CType) String() string {
+	switch t.kind {
+	case CKindPointer:
+		return t.elem.String() + " *"
+	case CKindArray:
+		return t.elem.String() + "[" + strconv.Itoa(t.length) + "]"
+	case CKindStruct, CKindUnion:
+		if t.name == "" {
+			return t.declaration("")
+		}
+		return t.kind.String() + " " + t.name
+	}
+	return t.name
+}
+
+// Declaration returns the C declaration of the type. For structs and unions
+// the full definition, including all fields, is returned.
+func (t *CType) Declaration() string {
+	switch t.kind {
+	case CKindStruct, CKindUnion:
+		return t.declaration("") + ";"
+	}
+	return t.String() + ";"
+}
+
+func (t *CType) declaration(indent string) string {
+	var b strings.Builder
+	b.WriteString(t.kind.String())
+	if t.name != "" {
+		b.WriteString(" " + t.name)
+	}
+	b.WriteString(" {\n")
+	for _, f := range t.fields {
+		b.WriteString(indent + "    " + f.Type.fieldDeclaration(f.Name, indent+"    ") + ";\n")
+	}
+	b.WriteString(indent + "}")
+	return b.String()
+}
+
+func (t *CType) fieldDeclaration(name, indent string) string {
+	switch t.kind {
+	case CKindArray:
+		return t.elem.fieldDeclaration(name, indent) + "[" + strconv.Itoa(t.length) + "]"
+	case CKindPointer, CKindString:
+		return strings.TrimSuffix(t.String(), "*") + "*" + name
+	case CKindStruct, CKindUnion:
+		if t.name == "" {
+			return t.declaration(indent) + " " + name
+		}
+	}
+	return t.String() + " " + name
+}
+
+// CFuncType describes the C side signature of a function.
+type CFuncType struct {
+	// Return is the return type of the function.
+	Return *CType
+
+	// Args are the parameter types of the function.
+	Args []*CType
+}
+
+// CFuncOf returns the C function type with the given return type and
+// parameter types. It can be passed to NewImportComplex as the C function
+// type.
+func CFuncOf(ret *CType, args ...*CType) *CFuncType {
+	return &CFuncType{Return: ret, Args: args}
+}
+
+// String returns the C function type, such as "double (*)(double)".
+func (f *CFuncType) String() string {
+	return f.Return.String() + " (*)(" + f.params() + ")"
+}
+
+// Declaration returns the C declaration of a function with the given name
+// and this function type, such as "double sqrt(double);".
+func (f *CFuncType) Declaration(name string) string {
+	ret := f.Return.String()
+	if !strings.HasSuffix(ret, "*") {
+		ret += " "
+	}
+	return ret + name + "(" + f.params() + ");"
+}
+
+func (f *CFuncType) params() string {
+	if len(f.Args) == 0 {
+		return "void"
+	}
+
+	params := make([]string, len(f.Args))
+	for i, arg := range f.Args {
+		params[i] = arg.String()
+	}
+	return strings.Join(params, ", ")
+}
+
+func (f *CFuncType) reflectType() (reflect.Type, error) {
+	in := make([]reflect.Type, len(f.Args))
+	for i, arg := range f.Args {
+		switch arg.kind {
+		case CKindVoid:
+			return nil, errIllegalVoidParameter
+		case CKindArray:
+			return nil, errArrayByValue
+		}
+		in[i] = arg.goType
+	}
+
+	out := make([]reflect.Type, 0)
+	if f.Return != nil && f.Return.kind != CKindVoid {
+		if f.Return.kind == CKindArray {
+			return nil, errArrayByValue
+		}
+		out = append(out, f.Return.goType)
+	}
+	return reflect.FuncOf(in, out, false), nil
+}
+
+func cFunctionType(cFnType interface{}) (reflect.Type, error) {
+	switch t := cFnType.(type) {
+	case reflect.Type:
+		if t.Kind() != reflect.Func {
+			return nil, errNoCFuncDef
+		}
+		return t, nil
+	case *CFuncType:
+		return t.reflectType()
+	}
+	return nil, errNoCFuncDef
+}
+
+func alignUp(offset, align uintptr) uintptr {
+	return (offset + align - 1) &^ (align - 1)
+}
+
+func newStructFfiType(nelements int, element func(i int) ffiType) ffiType {
+	t := C._newStructType(C.int(nelements))
+	for i := 0; i < nelements; i++ {
+		C._structTypeSet(t, C.int(i), element(i))
+	}
+	return t
+}
+
+func registerCType(t *CType) {
+	ctypes.Lock()
+	defer ctypes.Unlock()
+	ctypes.byRepr[t.goType] = t
+	ctypes.byFfiType[t.ffi] = t
+}
+
+func lookupCTypeByRepr(t reflect.Type) *CType {
+	ctypes.RLock()
+	defer ctypes.RUnlock()
+	return ctypes.byRepr[t]
+}
+
+func lookupCTypeByFfi(t ffiType) *CType {
+	ctypes.RLock()
+	defer ctypes.RUnlock()
+	return ctypes.byFfiType[t]
+}
+
+// cRepresentation returns the type, values of the given Go type are
+// represented by on the C side of the stub
+func cRepresentation(t reflect.Type) (reflect.Type, error) {
+	switch {
+	case t == TypeHandle, t == TypeMemory:
+		return TypeUintptr, nil
+	case t.Kind() == reflect.Struct && lookupCTypeByRepr(t) == nil:
+		ct, err := CTypeOf(t)
+		if err != nil {
+			return nil, err
+		}
+		return ct.goType, nil
+	}
+	return t, nil
+}
+
+func convertAggregate(value reflect.Value, t reflect.Type) reflect.Value {
+	if ct := lookupCTypeByRepr(t); ct != nil && ct.kind == CKindUnion {
+		return copyBytes(value, t)
+	}
+	if ct := lookupCTypeByRepr(value.Type()); ct != nil && ct.kind == CKindUnion {
+		return copyBytes(value, t)
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		result := reflect.New(t).Elem()
+		for i := 0; i < t.NumField(); i++ {
+			result.Field(i).Set(convertField(value.Field(i), t.Field(i).Type))
+		}
+		return result
+
+	case reflect.Array:
+		result := reflect.New(t).Elem()
+		for i := 0; i < t.Len(); i++ {
+			result.Index(i).Set(convertField(value.Index(i), t.Elem()))
+		}
+		return result
+	}
+	panic(fmt.Errorf("unhandled data type: %s", t.Kind().String()))
+}
+
+func convertField(value reflect.Value, t reflect.Type) reflect.Value {
+	switch {
+	case value.Type() == t:
+		return value
+	case t.Kind() == reflect.Struct || t.Kind() == reflect.Array:
+		return convertAggregate(value, t)
+	case t.Kind() == reflect.String && value.Kind() == reflect.Uintptr:
+		// strings of returned structs are owned by the C side
+		return reflect.ValueOf(goStringAt(uintptr(value.Uint()))).Convert(t)
+	case t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice:
+		// C memory is never referenced by Go pointers
+		return reflect.Zero(t)
+	}
+	return value.Convert(t)
+}
+
+func copyBytes(value reflect.Value, t reflect.Type) reflect.Value {
+	src := reflect.New(value.Type())
+	src.Elem().Set(value)
+	dst := reflect.New(t)
+
+	size := t.Size()
+	if value.Type().Size() < size {
+		size = value.Type().Size()
+	}
+	copy(unsafe.Slice((*byte)(dst.UnsafePointer()), size), unsafe.Slice((*byte)(src.UnsafePointer()), size))
+	return dst.Elem()
+}
@@ -0,0 +1,177 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"reflect"
+	"testing"
+)
+
+type point struct {
+	X int32
+	Y int32
+}
+
+type rect struct {
+	Min   point
+	Max   point
+	Flags [3]uint8
+}
+
+func TestStructByValue(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var add func(point, point) point
+	if err := l.Import("_point_add", &add); err != nil {
+		t.Errorf("Symbol _point_add failed to be imported: %v", err)
+		return
+	}
+
+	if p := add(point{1, 2}, point{3, 4}); p != (point{4, 6}) {
+		t.Errorf("expected {4 6}, got %v", p)
+	}
+
+	var area func(rect) int32
+	if err := l.Import("_rect_area", &area); err != nil {
+		t.Errorf("Symbol _rect_area failed to be imported: %v", err)
+		return
+	}
+
+	if a := area(rect{point{1, 1}, point{4, 5}, [3]uint8{1, 2, 3}}); a != 18 {
+		t.Errorf("expected 18, got %d", a)
+	}
+}
+
+func TestCFuncType(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	value, err := UnionOf("value",
+		CField{Name: "i", Type: CTypeInt64},
+		CField{Name: "d", Type: CTypeDouble},
+	)
+	if err != nil {
+		t.Errorf("failed to create union: %v", err)
+		return
+	}
+
+	if value.Size() != 8 || value.Align() != 8 {
+		t.Errorf("unexpected union layout: size %d, align %d", value.Size(), value.Align())
+	}
+
+	cFnType := CFuncOf(CTypeInt64, value)
+	goFnType := reflect.TypeOf(func(int64) int64 { return 0 })
+	fn, err := l.NewImportComplex("_value_int", goFnType, cFnType)
+	if err != nil {
+		t.Errorf("Symbol _value_int failed to be imported: %v", err)
+		return
+	}
+
+	if v := fn.(func(int64) int64)(1234); v != 1234 {
+		t.Errorf("expected 1234, got %d", v)
+	}
+}
+
+func TestCTypeLayout(t *testing.T) {
+	ct, err := CTypeOf(reflect.TypeOf(rect{}))
+	if err != nil {
+		t.Errorf("failed to derive C type: %v", err)
+		return
+	}
+
+	if ct.Kind() != CKindStruct || ct.Size() != 19+1 || ct.Align() != 4 {
+		t.Errorf("unexpected struct layout: size %d, align %d", ct.Size(), ct.Align())
+	}
+
+	fields := ct.Fields()
+	if len(fields) != 3 || fields[1].Offset != 8 || fields[2].Offset != 16 {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	if _, err := CTypeOf(reflect.TypeOf(struct{ s string }{})); err == nil {
+		t.Error("unexported fields should fail")
+	}
+	if _, err := ArrayOf(CTypeInt8, 0); err == nil {
+		t.Error("empty arrays should fail")
+	}
+}
+
+func TestCTypeOfCached(t *testing.T) {
+	for _, v := range []interface{}{[4]int32{}, [2]point{}, rect{}} {
+		first, err := CTypeOf(reflect.TypeOf(v))
+		if err != nil {
+			t.Errorf("failed to derive C type of %T: %v", v, err)
+			continue
+		}
+		if second, _ := CTypeOf(reflect.TypeOf(v)); second != first {
+			t.Errorf("C type of %T was derived again", v)
+		}
+	}
+}
+
+func TestCTypeDeclaration(t *testing.T) {
+	ct, err := StructOf("point",
+		CField{Name: "x", Type: CTypeInt32},
+		CField{Name: "y", Type: CTypeInt32},
+	)
+	if err != nil {
+		t.Errorf("failed to create struct: %v", err)
+		return
+	}
+
+	names, err := ArrayOf(CTypeString, 2)
+	if err != nil {
+		t.Errorf("failed to create array: %v", err)
+		return
+	}
+
+	outer, err := StructOf("shape",
+		CField{Name: "origin", Type: ct},
+		CField{Name: "data", Type: PointerTo(CTypeDouble)},
+		CField{Name: "names", Type: names},
+	)
+	if err != nil {
+		t.Errorf("failed to create struct: %v", err)
+		return
+	}
+
+	expected := "struct shape {\n" +
+		"    struct point origin;\n" +
+		"    double *data;\n" +
+		"    char *names[2];\n" +
+		"};"
+	if d := outer.Declaration(); d != expected {
+		t.Errorf("expected declaration:\n%s\ngot:\n%s", expected, d)
+	}
+
+	fn := CFuncOf(PointerTo(ct), ct, CTypeSizeT)
+	if d := fn.Declaration("move"); d != "struct point *move(struct point, size_t);" {
+		t.Errorf("unexpected function declaration: %s", d)
+	}
+	if s := CFuncOf(CTypeVoid).String(); s != "void (*)(void)" {
+		t.Errorf("unexpected function type: %s", s)
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	errEnumNoInteger   = errors.New("enum type is not an integer type")
+	errEnumIllegalSize = errors.New("enum size must be 1, 2, 4 or 8 bytes")
+	errEnumNoValues    = errors.New("enum has no values")
+)
+
+// EnumValueError is returned when a value of a registered enum type
+// is not one of its allowed values (or a combination of its flags).
+type EnumValueError struct {
+	Type  reflect.Type
+	Value int64
+}
+
+func (e *EnumValueError) Error() string {
+	return fmt.Sprintf("illegal value %d for enum %s", e.Value, e.Type)
+}
+
+type enumValue struct {
+	name  string
+	value uint64
+}
+
+type enumDef struct {
+	t      reflect.Type
+	ffi    ffiType
+	repr   reflect.Type
+	flags  bool
+	mask   uint64
+	values []enumValue
+}
+
+var enums = struct {
+	sync.RWMutex
+	defs map[reflect.Type]*enumDef
+}{defs: make(map[reflect.Type]*enumDef)}
+
+// RegisterEnum registers the named Go integer type t as a C enum with
+// the given size in bytes and its allowed values. Arguments of the type
+// passed to imported functions are validated against the allowed values
+// and fail with an EnumValueError otherwise, same for returned values.
+// The Go type is mapped to a C integer of the given size, independent
+// of the size of the Go type itself.
+func RegisterEnum(t reflect.Type, size int, values map[string]int64) error {
+	return registerEnum(t, size, false, values)
+}
+
+// RegisterFlags registers the named Go integer type t as a C bit-flag
+// enum with the given size in bytes. In addition to the given values,
+// any combination of them (including 0) is considered a valid value.
+func RegisterFlags(t reflect.Type, size int, values map[string]int64) error {
+	return registerEnum(t, size, true, values)
+}
+
+// EnumString returns the name of the given value of a registered enum
+// type. For bit-flag enums the names of all set flags are joined by |.
+func EnumString(value interface{}) (string, error) {
+	v := reflect.ValueOf(value)
+	def := lookupEnum(v.Type())
+	if def == nil {
+		return "", fmt.Errorf("type %s is not a registered enum", v.Type())
+	}
+	if err := def.check(v); err != nil {
+		return "", err
+	}
+	return def.name(enumBits(v)), nil
+}
+
+// CheckEnum validates the given value of a registered enum type,
+// returning an EnumValueError if it is none of the allowed values. Values
+// of types which are not registered as an enum are always valid.
+func CheckEnum(value interface{}) error {
+	return checkEnumValue(reflect.ValueOf(value))
+}
+
+func registerEnum(t reflect.Type, size int, flags bool, values map[string]int64) error {
+	if len(values) == 0 {
+		return errEnumNoValues
+	}
+
+	def := &enumDef{
+		t:     t,
+		flags: flags,
+	}
+
+	signed := false
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		signed = true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+	default:
+		return errEnumNoInteger
+	}
+
+	switch size {
+	case 1, 2, 4, 8:
+		def.ffi, def.repr = integerType(size, signed)
+	default:
+		return errEnumIllegalSize
+	}
+
+	for name, value := range values {
+		def.values = append(def.values, enumValue{name: name, value: uint64(value)})
+		def.mask |= uint64(value)
+	}
+	sort.Slice(def.values, func(i, j int) bool {
+		return def.values[i].value < def.values[j].value
+	})
+
+	enums.Lock()
+	defer enums.Unlock()
+	enums.defs[t] = def
+	return nil
+}
+
+func checkEnumValue(value reflect.Value) error {
+	if def := lookupEnum(value.Type()); def != nil {
+		return def.check(value)
+	}
+	return nil
+}
+
+func lookupEnum(t reflect.Type) *enumDef {
+	enums.RLock()
+	defer enums.RUnlock()
+	return enums.defs[t]
+}
+
+func enumBits(value reflect.Value) uint64 {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(value.Int())
+	}
+	return value.Uint()
+}
+
+func (e *enumDef) check(value reflect.Value) error {
+	bits := enumBits(value)
+	if e.flags {
+		if bits&^e.mask == 0 {
+			return nil
+		}
+	} else {
+		for _, v := range e.values {
+			if v.value == bits {
+				return nil
+			}
+		}
+	}
+	return &EnumValueError{Type: e.t, Value: int64(bits)}
+}
+
+func (e *enumDef) name(bits uint64) string {
+	for _, v := range e.values {
+		if v.value == bits {
+			return v.name
+		}
+	}
+
+	names := make([]string, 0)
+	for _, v := range e.values {
+		if v.value != 0 && bits&v.value == v.value {
+			names = append(names, v.name)
+			bits &^= v.value
+		}
+	}
+
+	if len(names) == 0 {
+		return fmt.Sprintf("%d", bits)
+	}
+	return strings.Join(names, "|")
+}
+
+// enumArgument validates a value of a registered enum type and converts
+// it into its C representation
+func enumArgument(value reflect.Value) (reflect.Value, error) {
+	def := lookupEnum(value.Type())
+	if def == nil {
+		return value, nil
+	}
+	if err := def.check(value); err != nil {
+		return value, err
+	}
+	return value.Convert(def.repr), nil
+}
@@ -0,0 +1,99 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testMode int32
+
+const (
+	testModeA testMode = iota
+	testModeB
+	testModeC
+)
+
+type testFlags uint32
+
+func init() {
+	if err := RegisterEnum(reflect.TypeOf(testMode(0)), 1, map[string]int64{
+		"A": int64(testModeA),
+		"B": int64(testModeB),
+		"C": int64(testModeC),
+	}); err != nil {
+		panic(err)
+	}
+
+	if err := RegisterFlags(reflect.TypeOf(testFlags(0)), 4, map[string]int64{
+		"READ":  1,
+		"WRITE": 2,
+		"EXEC":  4,
+	}); err != nil {
+		panic(err)
+	}
+}
+
+func TestExecuteEnum(t *testing.T) {
+	var fn func(testMode) (testMode, error)
+	libraryTestHelper(t, "_enum_next", testLibrary, &fn, func() {
+		v, err := fn(testModeA)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if v != testModeB {
+			t.Errorf("expected %d, got %d", testModeB, v)
+		}
+	})
+}
+
+func TestExecuteEnumIllegalArgument(t *testing.T) {
+	var fn func(testMode) (testMode, error)
+	libraryTestHelper(t, "_enum_next", testLibrary, &fn, func() {
+		_, err := fn(testMode(12))
+		if _, ok := err.(*EnumValueError); !ok {
+			t.Errorf("expected EnumValueError, got: %v", err)
+		}
+	})
+}
+
+func TestExecuteEnumIllegalResult(t *testing.T) {
+	var fn func(testMode) (testMode, error)
+	libraryTestHelper(t, "_enum_next", testLibrary, &fn, func() {
+		_, err := fn(testModeC)
+		if _, ok := err.(*EnumValueError); !ok {
+			t.Errorf("expected EnumValueError, got: %v", err)
+		}
+	})
+}
+
+func TestEnumString(t *testing.T) {
+	if s, err := EnumString(testModeB); err != nil || s != "B" {
+		t.Errorf("expected 'B', got '%s' (%v)", s, err)
+	}
+	if s, err := EnumString(testFlags(5)); err != nil || s != "READ|EXEC" {
+		t.Errorf("expected 'READ|EXEC', got '%s' (%v)", s, err)
+	}
+	if _, err := EnumString(testFlags(8)); err == nil {
+		t.Error("expected illegal flags value to fail")
+	}
+	if _, err := EnumString(int32(1)); err == nil {
+		t.Error("expected unregistered type to fail")
+	}
+}
@@ -0,0 +1,143 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Function represents an imported native function, whose signature is
+// only known at runtime, e.g. read from a configuration or schema.
+// Instead of a Go function type, the signature is defined by a set of
+// type descriptors (such as TypeInt32 or TypeFloat64) and arguments
+// are passed as a slice of values.
+type Function struct {
+	symbol   string
+	retType  reflect.Type
+	argTypes []reflect.Type
+	fn       reflect.Value
+}
+
+// NewFunction imports a symbol from the loaded library as a Function with
+// the given return type and argument types. TypeVoid can be used as the
+// return type of functions without a return value.
+func (l *Library) NewFunction(symbol string, retType reflect.Type, argTypes ...reflect.Type) (*Function, error) {
+	fn, err := l.NewImport(symbol, retType, true, argTypes...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Function{
+		symbol:   symbol,
+		retType:  retType,
+		argTypes: argTypes,
+		fn:       reflect.ValueOf(fn),
+	}, nil
+}
+
+// NewCFunction imports a symbol from the loaded library as a Function with
+// the given C function type (see CFuncOf). Arguments and results use the Go
+// types the C types are represented by (see CType.GoType), structs are
+// passed as values of the generated Go struct types.
+func (l *Library) NewCFunction(symbol string, fnType *CFuncType) (*Function, error) {
+	t, err := fnType.reflectType()
+	if err != nil {
+		return nil, err
+	}
+
+	retType := TypeVoid
+	if t.NumOut() > 0 {
+		retType = t.Out(0)
+	}
+
+	argTypes := make([]reflect.Type, t.NumIn())
+	for i := range argTypes {
+		argTypes[i] = t.In(i)
+	}
+	return l.NewFunction(symbol, retType, argTypes...)
+}
+
+// Symbol returns the name of the imported symbol.
+func (f *Function) Symbol() string {
+	return f.symbol
+}
+
+// ReturnType returns the return type descriptor of the function.
+func (f *Function) ReturnType() reflect.Type {
+	return f.retType
+}
+
+// ArgumentTypes returns the argument type descriptors of the function.
+func (f *Function) ArgumentTypes() []reflect.Type {
+	return append([]reflect.Type(nil), f.argTypes...)
+}
+
+// Call calls the native function with the given arguments. Arguments are
+// converted to the declared argument types, if they are assignable or both
+// are numeric types, otherwise an error is returned. The result is returned
+// as a value of the return type, or nil for void functions.
+func (f *Function) Call(args ...interface{}) (interface{}, error) {
+	if len(args) != len(f.argTypes) {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", f.symbol, len(f.argTypes), len(args))
+	}
+
+	values := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		t := f.argTypes[i]
+		if arg == nil {
+			values[i] = reflect.Zero(t)
+			continue
+		}
+
+		value := reflect.ValueOf(arg)
+		if value.Type() != t {
+			if !convertibleArgument(value.Type(), t) {
+				return nil, fmt.Errorf("argument %d of %s: %s not convertible to %s", i, f.symbol, value.Type(), t)
+			}
+			value = convertValue(value, t)
+		}
+		values[i] = value
+	}
+
+	results := f.fn.Call(values)
+	if err, _ := results[len(results)-1].Interface().(error); err != nil {
+		return nil, err
+	}
+
+	if len(results) == 1 {
+		return nil, nil
+	}
+	return results[0].Interface(), nil
+}
+
+// convertibleArgument reports if an argument of type from may be converted to
+// the declared type to. Unlike reflect.Type.ConvertibleTo, numbers are never
+// converted into strings.
+func convertibleArgument(from, to reflect.Type) bool {
+	if from.AssignableTo(to) {
+		return true
+	}
+	if isNumericKind(from.Kind()) && isNumericKind(to.Kind()) {
+		return true
+	}
+	return from.Kind() == to.Kind() && from.ConvertibleTo(to)
+}
+
+func isNumericKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Float64
+}
@@ -0,0 +1,166 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFunctionCall(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	fn, err := l.NewFunction("__sint32", TypeInt32, TypeInt32)
+	if err != nil {
+		t.Errorf("Symbol __sint32 failed to be imported: %v", err)
+		return
+	}
+
+	// int is converted to the declared int32
+	v, err := fn.Call(63)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if v != int32(31) {
+		t.Errorf("expected int32(31), got %#v", v)
+	}
+
+	if _, err := fn.Call(); err == nil {
+		t.Error("calling with missing arguments should fail")
+	}
+	if _, err := fn.Call("foo"); err == nil {
+		t.Error("calling with inconvertible arguments should fail")
+	}
+}
+
+func TestFunctionCallNoStringConversion(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	fn, err := l.NewFunction("_char", TypeString, TypeString, TypeInt)
+	if err != nil {
+		t.Errorf("Symbol _char failed to be imported: %v", err)
+		return
+	}
+
+	// int is convertible to string in Go, but must not be passed as a rune
+	if _, err := fn.Call(65, 6); err == nil {
+		t.Error("calling with an int as string argument should fail")
+	}
+	if _, err := fn.Call("hello", "6"); err == nil {
+		t.Error("calling with a string as int argument should fail")
+	}
+}
+
+func TestCFunction(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	fn, err := l.NewCFunction("__sint32", CFuncOf(CTypeInt32, CTypeInt32))
+	if err != nil {
+		t.Errorf("Symbol __sint32 failed to be imported: %v", err)
+		return
+	}
+	if v, err := fn.Call(63); err != nil || v != int32(31) {
+		t.Errorf("expected int32(31), got %#v (%v)", v, err)
+	}
+
+	pointType, err := StructOf("point",
+		CField{Name: "x", Type: CTypeInt32},
+		CField{Name: "y", Type: CTypeInt32},
+	)
+	if err != nil {
+		t.Errorf("failed to create struct: %v", err)
+		return
+	}
+
+	add, err := l.NewCFunction("_point_add", CFuncOf(pointType, pointType, pointType))
+	if err != nil {
+		t.Errorf("Symbol _point_add failed to be imported: %v", err)
+		return
+	}
+
+	newPoint := func(x, y int32) interface{} {
+		p := reflect.New(pointType.GoType()).Elem()
+		p.Field(0).SetInt(int64(x))
+		p.Field(1).SetInt(int64(y))
+		return p.Interface()
+	}
+
+	v, err := add.Call(newPoint(1, 2), newPoint(3, 4))
+	if err != nil || v != newPoint(4, 6) {
+		t.Errorf("expected {4 6}, got %v (%v)", v, err)
+	}
+
+	if _, err := l.NewCFunction("_point_add", CFuncOf(pointType, CTypeVoid)); err == nil {
+		t.Error("void parameters should fail")
+	}
+}
+
+func TestFunctionCallVoid(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	fn, err := l.NewFunction("empty", TypeVoid)
+	if err != nil {
+		t.Errorf("Symbol empty failed to be imported: %v", err)
+		return
+	}
+
+	v, err := fn.Call()
+	if err != nil || v != nil {
+		t.Errorf("expected nil result, got %v (%v)", v, err)
+	}
+}
+
+func TestFunctionCallString(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	fn, err := l.NewFunction("_char", TypeString, TypeString, TypeInt)
+	if err != nil {
+		t.Errorf("Symbol _char failed to be imported: %v", err)
+		return
+	}
+
+	v, err := fn.Call("hello", 6)
+	if err != nil || v != "hello" {
+		t.Errorf("expected 'hello', got %v (%v)", v, err)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"reflect"
+)
+
+// Bind imports a symbol from the loaded library as a function of type F,
+// which must be a Go function type. The signature is checked and mapped
+// once, as with Import, and the returned function is strongly typed.
+//
+//	sqrt, err := goffi.Bind[func(float64) float64](library, "sqrt")
+func Bind[F any](library *Library, symbol string, options ...ImportOption) (F, error) {
+	var fn F
+	if reflect.TypeOf(&fn).Elem().Kind() != reflect.Func {
+		return fn, errNoGoFuncDef
+	}
+
+	err := library.Import(symbol, &fn, options...)
+	return fn, err
+}
+
+// MustBind imports a symbol from the loaded library as a function of type F,
+// like Bind, but panics if the symbol can't be imported.
+func MustBind[F any](library *Library, symbol string, options ...ImportOption) F {
+	fn, err := Bind[F](library, symbol, options...)
+	if err != nil {
+		panic(err)
+	}
+	return fn
+}
+
+// BindComplex imports a symbol from the loaded library as a function of type F,
+// mapped onto the C function type cFnType, like NewImportComplex.
+func BindComplex[F any](library *Library, symbol string, cFnType interface{},
+	options ...ImportOption) (F, error) {
+
+	var fn F
+	fnType := reflect.TypeOf(&fn).Elem()
+	if fnType.Kind() != reflect.Func {
+		return fn, errNoGoFuncDef
+	}
+
+	f, err := library.NewImportComplex(symbol, fnType, cFnType, options...)
+	if err != nil {
+		return fn, err
+	}
+	return f.(F), nil
+}
+
+// Call calls the given symbol with the given arguments and returns its result
+// as R. The C signature is derived from the Go types of the arguments and R.
+// The mapped function is cached, hence the signature is only checked on the
+// first call per symbol and set of types. An untyped nil argument is passed
+// as a NULL pointer.
+//
+//	pid, err := goffi.Call[int32](library, "getpid")
+func Call[R any](library *Library, symbol string, args ...any) (R, error) {
+	var result R
+	out := []reflect.Type{reflect.TypeOf(&result).Elem(), TypeError}
+
+	results, err := library.call(symbol, out, args)
+	if err != nil {
+		return result, err
+	}
+
+	if err, _ := results[1].Interface().(error); err != nil {
+		return result, err
+	}
+	return results[0].Interface().(R), nil
+}
+
+// CallVoid calls the given symbol with the given arguments, like Call, but for
+// functions without a return value.
+func CallVoid(library *Library, symbol string, args ...any) error {
+	results, err := library.call(symbol, []reflect.Type{TypeError}, args)
+	if err != nil {
+		return err
+	}
+
+	err, _ = results[0].Interface().(error)
+	return err
+}
+
+func (l *Library) call(symbol string, out []reflect.Type, args []any) ([]reflect.Value, error) {
+	in := make([]reflect.Type, len(args))
+	values := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		values[i] = reflect.ValueOf(arg)
+		if !values[i].IsValid() {
+			values[i] = reflect.Zero(TypeUnsafePointer)
+		}
+		in[i] = values[i].Type()
+	}
+
+	fnType := reflect.FuncOf(in, out, false)
+	fn, err := l.cachedCall(symbol, fnType)
+	if err != nil {
+		return nil, err
+	}
+	return fn.Call(values), nil
+}
+
+func (l *Library) cachedCall(symbol string, fnType reflect.Type) (reflect.Value, error) {
+	key := symbol + " " + fnType.String()
+
+	l.m.Lock()
+	fn, ok := l.callCache[key]
+	l.m.Unlock()
+	if ok {
+		return fn, nil
+	}
+
+	f, err := l.NewImportComplex(symbol, fnType, fnType)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	fn = reflect.ValueOf(f)
+	l.m.Lock()
+	l.callCache[key] = fn
+	l.m.Unlock()
+	return fn, nil
+}
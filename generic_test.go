@@ -0,0 +1,111 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBind(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	sqrt, err := Bind[func(float64) float64](l, "_sqrt")
+	if err != nil {
+		t.Errorf("Symbol _sqrt failed to be imported: %v", err)
+		return
+	}
+	if v := sqrt(9.); v != 3. {
+		t.Errorf("expected 3, got %f", v)
+	}
+}
+
+func TestBindNoFunction(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	if _, err := Bind[int](l, "_sqrt"); err != errNoGoFuncDef {
+		t.Errorf("expected errNoGoFuncDef, got: %v", err)
+	}
+}
+
+func TestBindComplex(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	cFnType := reflect.FuncOf([]reflect.Type{TypeFloat64}, []reflect.Type{TypeFloat64}, false)
+	sqrt, err := BindComplex[func(int) int](l, "_sqrt", cFnType)
+	if err != nil {
+		t.Errorf("Symbol _sqrt failed to be imported: %v", err)
+		return
+	}
+	if v := sqrt(9); v != 3 {
+		t.Errorf("expected 3, got %d", v)
+	}
+}
+
+func TestCall(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	for i := int32(0); i < 3; i++ {
+		v, err := Call[int32](l, "__sint32", 40+i)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if v != 8+i {
+			t.Errorf("expected %d, got %d", 8+i, v)
+		}
+	}
+
+	// same symbol, different signature
+	v, err := Call[int64](l, "__sint64", int64(100))
+	if err != nil || v != 36 {
+		t.Errorf("expected 36, got %d (%v)", v, err)
+	}
+
+	if err := CallVoid(l, "empty"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// untyped nil is passed as NULL
+	if v, err := Call[int32](l, "_is_null", nil); err != nil || v != 1 {
+		t.Errorf("expected NULL to be passed, got %d (%v)", v, err)
+	}
+
+	if _, err := Call[int32](l, "_not_existing"); err == nil {
+		t.Error("calling a missing symbol should fail")
+	}
+}
@@ -1,5 +1,5 @@
 module github.com/clevabit/libgoffi
 
-go 1.12
+go 1.22
 
 require github.com/achille-roussel/go-dl v0.0.0-20160112015913-00e9c7be8e78
@@ -27,10 +27,13 @@ typedef void* _pointer;
 import "C"
 
 import (
+	"context"
 	"errors"
 	"github.com/achille-roussel/go-dl"
 	"reflect"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"unsafe"
 )
 
@@ -42,6 +45,10 @@ var (
 	errGoFuncMultiReturn        = errors.New("multiple return values for Go impossible (except error as second return value)")
 	errVariadicTypeNotSupported = errors.New("variadic parameters are not supported")
 	errIllegalVoidParameter     = errors.New("void is not a legal parameter type")
+	errOutParamsMismatch        = errors.New("number of out parameters doesn't match the return values")
+	errOutParamNoPointer        = errors.New("out parameter is not a pointer type")
+	errIllegalOutParamPosition  = errors.New("illegal out parameter position")
+	errParameterCountMismatch   = errors.New("number of C parameters doesn't match the Go parameters")
 )
 
 type status int
@@ -89,11 +96,20 @@ const (
 // library file (.so or .dylib). All exported symbols of this
 // library can be imported and mapped to Go functions.
 type Library struct {
-	lib         dl.Library
-	name        string
-	m           sync.Mutex
-	cifCache    map[string]*C.ffi_cif
-	symbolCache map[string]uintptr
+	lib          dl.Library
+	name         string
+	m            sync.Mutex
+	cifCache     map[string]*C.ffi_cif
+	symbolCache  map[string]uintptr
+	callCache    map[string]reflect.Value
+	debugInfos   map[string]*debugInfo
+	thread       atomic.Value
+	semaphore    atomic.Value
+	interceptors atomic.Value
+	metrics      atomic.Value
+	checks       atomic.Value
+	state        sync.RWMutex
+	closed       bool
 }
 
 // NewLibrary loads a library file and create a Library instance bound to it.
@@ -112,18 +128,31 @@ func NewLibrary(library string, mode Mode) (*Library, error) {
 		return nil, err
 	}
 
-	return &Library{
+	l := &Library{
 		lib:         lib,
 		name:        library,
 		cifCache:    make(map[string]*C.ffi_cif, 0),
 		symbolCache: make(map[string]uintptr, 0),
-	}, nil
+		callCache:   make(map[string]reflect.Value, 0),
+		debugInfos:  make(map[string]*debugInfo, 0),
+	}
+	l.thread.Store((*Thread)(nil))
+	l.semaphore.Store((chan struct{})(nil))
+	l.interceptors.Store([]Interceptor(nil))
+	l.metrics.Store((*Metrics)(nil))
+	l.checks.Store(false)
+	return l, nil
 }
 
 // Close closes the loaded Library. This is necessary to be called
 // to clean internal state and the caches, which speeds up
-// multiple requests for the same symbols.
+// multiple requests for the same symbols. Destructors bound to
+// Handles aren't called anymore, after the Library was closed.
 func (l *Library) Close() error {
+	l.state.Lock()
+	defer l.state.Unlock()
+	l.closed = true
+
 	for _, cif := range l.cifCache {
 		if cif.arg_types != nil {
 			C.free(unsafe.Pointer(cif.arg_types))
@@ -152,10 +181,90 @@ func (l *Library) Symbol(name string) (uintptr, error) {
 	return s, nil
 }
 
+// ImportOption configures how an imported function is mapped
+// onto its native counterpart.
+type ImportOption func(*importConfig)
+
+type importConfig struct {
+	outParams      bool
+	outParamsIndex []int
+	destructor     string
+	self           reflect.Value
+	verify         bool
+	debugFile      string
+	thread         *Thread
+	cancel         func()
+	cancelSymbol   string
+	cancelSignal   syscall.Signal
+	guarded        bool
+	noCopyBack     bool
+	stringArray    *stringArray
+	stringParams   map[int]StringMode
+	stringResult   StringMode
+}
+
+// OutParams maps additional (non-error) return values of the Go function
+// onto hidden out-pointer parameters of the C function. The first return
+// value of the Go function is mapped to the C return value, while every
+// further return value is read from the memory a pointer parameter points
+// to after the call.
+// The positions define the C parameter index of each additional return
+// value, in the order of the Go return values. If no positions are given,
+// the out-pointers are expected to be the trailing C parameters.
+// If as many positions as non-error return values are given, all Go return
+// values are mapped to out-pointers and the C return value is ignored.
+func OutParams(positions ...int) ImportOption {
+	return func(config *importConfig) {
+		config.outParams = true
+		config.outParamsIndex = positions
+	}
+}
+
+// Self passes the given native object as an implicit first parameter
+// to the C function, which isn't part of the Go function signature.
+// This maps C object APIs like foo_read(foo*, ...) onto Go functions
+// without the receiver parameter. The object can be a *Handle, an
+// unsafe.Pointer or a uintptr.
+// When using NewImportComplex, the C function type has to declare
+// the self parameter as its first parameter.
+func Self(self interface{}) ImportOption {
+	return func(config *importConfig) {
+		config.self = reflect.ValueOf(self)
+	}
+}
+
+type signature struct {
+	symbol        string
+	goFnType      reflect.Type
+	cFnType       reflect.Type
+	returnsError  bool
+	returnsValue  bool
+	outParams     []int
+	destructor    func(uintptr)
+	self          reflect.Value
+	guarded       bool
+	scoped        bool
+	pointerChecks func() bool
+	copyBack      bool
+	references    map[int]*CType
+	stringArray   *stringArray
+	stringParams  []StringMode
+	stringResult  StringMode
+}
+
+func (s *signature) outParam(index int) int {
+	for i, p := range s.outParams {
+		if p == index {
+			return i
+		}
+	}
+	return -1
+}
+
 // Import imports a symbol from the loaded library. The given target must be a
 // pointer to a function variable in Go. The function signature is used
 // to automatically map the Go type signature to the C function.
-func (l *Library) Import(symbol string, target interface{}) error {
+func (l *Library) Import(symbol string, target interface{}, options ...ImportOption) error {
 	tpt := reflect.TypeOf(target)
 
 	if tpt.Kind() != reflect.Ptr || tpt.Elem().Kind() != reflect.Func {
@@ -165,32 +274,36 @@ func (l *Library) Import(symbol string, target interface{}) error {
 	tv = reflect.Indirect(tv)
 	tt := tv.Type()
 
-	returnsError, err := precheckResultTypes(tt)
+	config := newImportConfig(options)
+	returnsError, err := precheckResultTypes(tt, config)
 	if err != nil {
 		return err
 	}
 
-	outType := wrapReturnType(tt)
-
 	// Meaningless since there is no Void in Go, still for documentation :)
 	tt, err = cleanArgumentTypes(tt)
 	if err != nil {
 		return err
 	}
 
-	inTypes, inTypesPtr, nargs := wrapArgumentTypes(tt)
+	// a context and a scope are only part of the Go side
+	ct := goSideFnType(tt)
+	if config.self.IsValid() {
+		ct = prependArgumentType(ct, config.self.Type())
+	}
 
-	cif, err := l.getOrCreateCif(symbol, outType, inTypesPtr, nargs)
-	if err != nil {
-		return err
+	if config.outParams {
+		ct, err = makeOutParamsFnType(ct, returnsError, config)
+		if err != nil {
+			return err
+		}
 	}
 
-	funcPtr, err := l.makeFunctionPointer(symbol)
+	stub, err := l.newStub(symbol, tt, ct, returnsError, config)
 	if err != nil {
 		return err
 	}
 
-	stub := makeStub(tt, tt, cif, funcPtr, outType, inTypes, returnsError)
 	funcValue := reflect.MakeFunc(tt, stub)
 	tv.Set(funcValue)
 	return nil
@@ -220,23 +333,30 @@ func (l *Library) NewImport(symbol string, retType reflect.Type, returnsError bo
 // NewImportComplex imports a symbol from the loaded library. The function type, which is
 // generated, is defined by the goFnType reflective Type instance. Due to more complex type
 // mappings the cFnType reflective Type instance represents the parameter and return type
-// definitions of the C side. It can use CGO C type definitions, as well as Go types, which
-// will automatically translated to their respective C types.
-func (l *Library) NewImportComplex(symbol string, goFnType reflect.Type, cFnType reflect.Type) (interface{}, error) {
+// definitions of the C side. It can use CGO C type definitions, marker types (such as
+// CLong or CString) for packages without cgo, as well as Go types, which will automatically
+// translated to their respective C types. Alternatively cFnType can be a *CFuncType,
+// describing the C side using C type descriptors (see CFuncOf).
+// When mapping out-pointers (see OutParams), cFnType must declare the out-pointer
+// parameters as pointer types, while goFnType declares them as return values.
+func (l *Library) NewImportComplex(symbol string, goFnType reflect.Type, cFnType interface{},
+	options ...ImportOption) (interface{}, error) {
+
 	if goFnType.Kind() != reflect.Func {
 		return nil, errNoGoFuncDef
 	}
-	if cFnType.Kind() != reflect.Func {
-		return nil, errNoCFuncDef
+	ct, err := cFunctionType(cFnType)
+	if err != nil {
+		return nil, err
 	}
 
-	returnsError, err := precheckResultTypes(goFnType)
+	config := newImportConfig(options)
+	returnsError, err := precheckResultTypes(goFnType, config)
 	if err != nil {
 		return nil, err
 	}
 
-	outType := wrapReturnType(cFnType)
-	cFnType, err = cleanArgumentTypes(cFnType)
+	ct, err = cleanArgumentTypes(ct)
 	if err != nil {
 		return nil, err
 	}
@@ -246,9 +366,63 @@ func (l *Library) NewImportComplex(symbol string, goFnType reflect.Type, cFnType
 		return nil, err
 	}
 
-	inTypes, inTypesPtr, nargs := wrapArgumentTypes(cFnType)
+	stub, err := l.newStub(symbol, goFnType, ct, returnsError, config)
+	if err != nil {
+		return nil, err
+	}
+	return reflect.MakeFunc(goFnType, stub).Interface(), nil
+}
+
+func (l *Library) newStub(symbol string, goFnType, cFnType reflect.Type, returnsError bool,
+	config *importConfig) (stubFunc, error) {
+
+	goFnType, hasContext := contextFnType(goFnType)
+	if hasContext && !returnsError {
+		return nil, errContextWithoutError
+	}
+	goFnType, hasScope := scopeFnType(goFnType)
+	if err := checkMarkerTypes(goFnType); err != nil {
+		return nil, err
+	}
+
+	cFnType, err := representFnType(cFnType)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := newSignature(goFnType, cFnType, returnsError, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.verifySignature(symbol, cFnType, config); err != nil {
+		return nil, err
+	}
+
+	sig.symbol = symbol
+	sig.guarded = config.guarded
+	sig.scoped = hasScope
+	sig.pointerChecks = l.PointerChecks
+	sig.copyBack = !config.noCopyBack
+	sig.references = newReferences(goFnType, config)
+
+	sig.stringArray, err = newStringArray(goFnType, sig.returnsValue, config)
+	if err != nil {
+		return nil, err
+	}
+
+	sig.stringParams, sig.stringResult, err = newStringModes(goFnType, sig.returnsValue, config)
+	if err != nil {
+		return nil, err
+	}
 
-	cif, err := l.getOrCreateCif(symbol, outType, inTypesPtr, nargs)
+	sig.destructor, err = l.importDestructor(config.destructor)
+	if err != nil {
+		return nil, err
+	}
+
+	outType := wrapReturnType(cFnType)
+	cif, err := l.getOrCreateCif(symbol, outType, cFnType)
 	if err != nil {
 		return nil, err
 	}
@@ -257,23 +431,38 @@ func (l *Library) NewImportComplex(symbol string, goFnType reflect.Type, cFnType
 	if err != nil {
 		return nil, err
 	}
-	stub := makeStub(goFnType, cFnType, cif, funcPtr, outType, inTypes, returnsError)
-	return reflect.MakeFunc(goFnType, stub).Interface(), nil
+	call := l.threadStub(sig, nativeCall(sig, makeStub(sig, cif, funcPtr, outType)), config)
+	call = l.concurrencyStub(sig, call)
+
+	stub := func(values []reflect.Value) []reflect.Value {
+		return call(context.Background(), values)
+	}
+	if hasContext {
+		if stub, err = l.contextStub(sig, call, config); err != nil {
+			return nil, err
+		}
+	}
+	stub = l.metricsStub(symbol, sig, stub)
+	return l.interceptStub(symbol, sig, hasContext, stub), nil
 }
 
-func (l *Library) getOrCreateCif(symbol string, retType ffiType, inTypesPtr *ffiType, nargs int) (*C.ffi_cif, error) {
+func (l *Library) getOrCreateCif(symbol string, retType ffiType, cFnType reflect.Type) (*C.ffi_cif, error) {
 	l.m.Lock()
 	defer l.m.Unlock()
 
-	cif := l.cifCache[symbol]
+	// the same symbol may be imported using different signatures
+	key := symbol + " " + cFnType.String()
+	cif := l.cifCache[key]
 	if cif == nil {
+		_, inTypesPtr, nargs := wrapArgumentTypes(cFnType)
 		c, err := newCif(retType, inTypesPtr, nargs)
 		if err != nil {
+			C.free(unsafe.Pointer(inTypesPtr))
 			return nil, err
 		}
 
 		cif = c
-		l.cifCache[symbol] = c
+		l.cifCache[key] = c
 	}
 
 	return cif, nil
@@ -298,11 +487,32 @@ func newCif(retType ffiType, inTypesPtr *ffiType, nargs int) (*C.ffi_cif, error)
 	return &cif, nil
 }
 
-func precheckResultTypes(fnType reflect.Type) (bool, error) {
+func newImportConfig(options []ImportOption) *importConfig {
+	config := &importConfig{}
+	for _, option := range options {
+		option(config)
+	}
+	return config
+}
+
+func precheckResultTypes(fnType reflect.Type, config *importConfig) (bool, error) {
 	if fnType.IsVariadic() {
 		return false, errVariadicTypeNotSupported
 	}
 
+	if config.outParams {
+		numOut := fnType.NumOut()
+		if numOut > 0 && fnType.Out(numOut-1) == TypeError {
+			return true, nil
+		}
+		return false, nil
+	}
+
+	// functions without a return value may still map out errors
+	if fnType.NumOut() == 1 && fnType.Out(0) == TypeError {
+		return true, nil
+	}
+
 	returnsError := false
 	if fnType.NumOut() > 1 {
 		if fnType.NumOut() > 2 {
@@ -316,6 +526,120 @@ func precheckResultTypes(fnType reflect.Type) (bool, error) {
 	return returnsError, nil
 }
 
+func numResultValues(fnType reflect.Type, returnsError bool) int {
+	if returnsError {
+		return fnType.NumOut() - 1
+	}
+	return fnType.NumOut()
+}
+
+func numOutParams(goFnType reflect.Type, returnsError bool, config *importConfig) (int, error) {
+	numValues := numResultValues(goFnType, returnsError)
+	if len(config.outParamsIndex) == 0 {
+		if numValues == 0 {
+			return 0, nil
+		}
+		return numValues - 1, nil
+	}
+
+	numOut := len(config.outParamsIndex)
+	if numOut != numValues && numOut != numValues-1 {
+		return 0, errOutParamsMismatch
+	}
+	return numOut, nil
+}
+
+func makeOutParamsFnType(goFnType reflect.Type, returnsError bool, config *importConfig) (reflect.Type, error) {
+	numOut, err := numOutParams(goFnType, returnsError, config)
+	if err != nil {
+		return nil, err
+	}
+
+	numValues := numResultValues(goFnType, returnsError)
+	firstOut := numValues - numOut
+
+	nargs := goFnType.NumIn() + numOut
+	positions := config.outParamsIndex
+	if len(positions) == 0 {
+		positions = make([]int, numOut)
+		for i := range positions {
+			positions[i] = goFnType.NumIn() + i
+		}
+	}
+
+	in := make([]reflect.Type, nargs)
+	for i, p := range positions {
+		if p < 0 || p >= nargs || in[p] != nil {
+			return nil, errIllegalOutParamPosition
+		}
+		in[p] = reflect.PtrTo(unwrapType(wrapType(goFnType.Out(firstOut + i))))
+	}
+
+	for i, j := 0, 0; i < nargs; i++ {
+		if in[i] == nil {
+			in[i] = goFnType.In(j)
+			j++
+		}
+	}
+
+	out := make([]reflect.Type, 0)
+	if firstOut > 0 {
+		out = append(out, goFnType.Out(0))
+	}
+	return reflect.FuncOf(in, out, false), nil
+}
+
+func newSignature(goFnType, cFnType reflect.Type, returnsError bool, config *importConfig) (*signature, error) {
+	sig := &signature{
+		goFnType:     goFnType,
+		cFnType:      cFnType,
+		returnsError: returnsError,
+		returnsValue: numResultValues(goFnType, returnsError) > 0,
+		self:         config.self,
+	}
+
+	numIn := goFnType.NumIn()
+	if sig.self.IsValid() {
+		numIn++
+	}
+
+	if !config.outParams {
+		if cFnType.NumIn() != numIn {
+			return nil, errParameterCountMismatch
+		}
+		return sig, nil
+	}
+
+	numOut, err := numOutParams(goFnType, returnsError, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if cFnType.NumIn() != numIn+numOut {
+		return nil, errOutParamsMismatch
+	}
+
+	sig.outParams = config.outParamsIndex
+	if len(sig.outParams) == 0 {
+		sig.outParams = make([]int, numOut)
+		for i := range sig.outParams {
+			sig.outParams[i] = numIn + i
+		}
+	}
+
+	for _, p := range sig.outParams {
+		if p < 0 || p >= cFnType.NumIn() {
+			return nil, errIllegalOutParamPosition
+		}
+		if cFnType.In(p).Kind() != reflect.Ptr {
+			return nil, errOutParamNoPointer
+		}
+	}
+
+	sig.returnsValue = numResultValues(goFnType, returnsError) > numOut
+	return sig, nil
+}
+
 func cleanArgumentTypes(fnType reflect.Type) (reflect.Type, error) {
 	in := make([]reflect.Type, 0)
 	out := make([]reflect.Type, 0)
@@ -344,6 +668,40 @@ func cleanArgumentTypes(fnType reflect.Type) (reflect.Type, error) {
 	return reflect.FuncOf(in, out, fnType.IsVariadic()), nil
 }
 
+func prependArgumentType(fnType reflect.Type, t reflect.Type) reflect.Type {
+	in := []reflect.Type{t}
+	for i := 0; i < fnType.NumIn(); i++ {
+		in = append(in, fnType.In(i))
+	}
+
+	out := make([]reflect.Type, fnType.NumOut())
+	for i := range out {
+		out[i] = fnType.Out(i)
+	}
+	return reflect.FuncOf(in, out, fnType.IsVariadic())
+}
+
+func representFnType(fnType reflect.Type) (reflect.Type, error) {
+	in := make([]reflect.Type, fnType.NumIn())
+	for i := range in {
+		t, err := cRepresentation(fnType.In(i))
+		if err != nil {
+			return nil, err
+		}
+		in[i] = t
+	}
+
+	out := make([]reflect.Type, fnType.NumOut())
+	for i := range out {
+		t, err := cRepresentation(fnType.Out(i))
+		if err != nil {
+			return nil, err
+		}
+		out[i] = t
+	}
+	return reflect.FuncOf(in, out, fnType.IsVariadic()), nil
+}
+
 func wrapArgumentTypes(fnType reflect.Type) ([]ffiType, *ffiType, int) {
 	nargs := fnType.NumIn()
 
@@ -361,7 +719,7 @@ func wrapArgumentTypes(fnType reflect.Type) ([]ffiType, *ffiType, int) {
 
 func wrapReturnType(fnType reflect.Type) ffiType {
 	retType := typeVoid
-	if fnType.NumOut() > 0 {
+	if fnType.NumOut() > 0 && fnType.Out(0) != TypeError {
 		retType = wrapType(fnType.Out(0))
 	}
 	return retType
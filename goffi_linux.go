@@ -17,5 +17,5 @@
 
 package libgoffi
 
-//#cgo LDFLAGS: -lffi
+//#cgo LDFLAGS: -lffi -ldl
 import "C"
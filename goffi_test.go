@@ -575,13 +575,85 @@ func TestExecuteCharPtrIntInCharPtrOut(t *testing.T) {
 	})
 }
 
-func libraryTestHelper(t *testing.T, symbol, library string, fn interface{}, test func()) {
+func TestExecuteTrailingOutParam(t *testing.T) {
+	var fn func(int32, int32) (int32, int32, error)
+	libraryTestHelper(t, "_div", testLibrary, &fn, func() {
+		q, r, err := fn(17, 5)
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if q != 3 || r != 2 {
+			t.Errorf("expected 3 remainder 2, got %d remainder %d", q, r)
+		}
+	}, OutParams())
+}
+
+func TestExecuteLeadingOutParam(t *testing.T) {
+	var fn func(int32, int32) (int32, int32)
+	libraryTestHelper(t, "_rdiv", testLibrary, &fn, func() {
+		q, r := fn(17, 5)
+		if q != 3 || r != 2 {
+			t.Errorf("expected 3 remainder 2, got %d remainder %d", q, r)
+		}
+	}, OutParams(0))
+}
+
+func TestExecuteOutParamsOnly(t *testing.T) {
+	var fn func(int32, int32) (int32, int32)
+	libraryTestHelper(t, "_divmod", testLibrary, &fn, func() {
+		q, r := fn(17, 5)
+		if q != 3 || r != 2 {
+			t.Errorf("expected 3 remainder 2, got %d remainder %d", q, r)
+		}
+	}, OutParams(1, 3))
+}
+
+func TestNewImportComplexOutParam(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	goFnType := reflect.FuncOf([]reflect.Type{TypeInt, TypeInt}, []reflect.Type{TypeInt, TypeInt}, false)
+	cFnType := reflect.FuncOf([]reflect.Type{TypeInt32, TypeInt32, reflect.PtrTo(TypeInt32)},
+		[]reflect.Type{TypeInt32}, false)
+
+	fn, err := l.NewImportComplex("_div", goFnType, cFnType, OutParams())
+	if err != nil {
+		t.Errorf("Symbol _div failed to be imported: %v", err)
+		return
+	}
+
+	q, r := fn.(func(int, int) (int, int))(17, 5)
+	if q != 3 || r != 2 {
+		t.Errorf("expected 3 remainder 2, got %d remainder %d", q, r)
+	}
+}
+
+func TestOutParamsMismatch(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var fn func(int32, int32) (int32, int32)
+	err = l.Import("_div", &fn, OutParams(0, 1, 2))
+	if err != errOutParamsMismatch {
+		t.Errorf("expected out params mismatch error, got: %v", err)
+	}
+}
+
+func libraryTestHelper(t *testing.T, symbol, library string, fn interface{}, test func(), options ...ImportOption) {
 	l, err := NewLibrary(library, BindNow)
 	if err != nil {
 		t.Errorf("Library %s failed to be initialized: %v", library, err)
 		return
 	}
-	if err := l.Import(symbol, fn); err != nil {
+	if err := l.Import(symbol, fn, options...); err != nil {
 		t.Errorf("Symbol %s failed to be imported: %v", symbol, err)
 		return
 	}
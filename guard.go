@@ -0,0 +1,146 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+/*
+#include <ffi.h>
+#include <pthread.h>
+#include <setjmp.h>
+#include <signal.h>
+#include <stdint.h>
+#include <string.h>
+
+static __thread sigjmp_buf *_guard_jump = NULL;
+static __thread volatile int _guard_signal = 0;
+static __thread volatile uintptr_t _guard_address = 0;
+
+static struct sigaction _guard_previous[NSIG];
+static pthread_once_t _guard_once = PTHREAD_ONCE_INIT;
+static const int _guard_signals[] = { SIGSEGV, SIGBUS, SIGFPE };
+
+static void _guard_forward(int sig, siginfo_t *info, void *context) {
+	struct sigaction *previous = &_guard_previous[sig];
+	if (previous->sa_flags & SA_SIGINFO) {
+		previous->sa_sigaction(sig, info, context);
+	} else if (previous->sa_handler == SIG_DFL) {
+		signal(sig, SIG_DFL);
+		raise(sig);
+	} else if (previous->sa_handler != SIG_IGN) {
+		previous->sa_handler(sig);
+	}
+}
+
+static void _guard_handler(int sig, siginfo_t *info, void *context) {
+	sigjmp_buf *jump = _guard_jump;
+	if (jump == NULL) {
+		// not inside of a guarded call, e.g. a fault in Go code
+		_guard_forward(sig, info, context);
+		return;
+	}
+
+	_guard_jump = NULL;
+	_guard_signal = sig;
+	_guard_address = (uintptr_t)info->si_addr;
+	siglongjmp(*jump, 1);
+}
+
+static void _guard_install() {
+	struct sigaction action;
+	memset(&action, 0, sizeof(action));
+	action.sa_sigaction = _guard_handler;
+	action.sa_flags = SA_SIGINFO | SA_ONSTACK | SA_NODEFER;
+	sigemptyset(&action.sa_mask);
+
+	for (int i = 0; i < sizeof(_guard_signals) / sizeof(int); i++) {
+		sigaction(_guard_signals[i], &action, &_guard_previous[_guard_signals[i]]);
+	}
+}
+
+static void _guard_init() {
+	pthread_once(&_guard_once, _guard_install);
+}
+
+static int _guarded_ffi_call(ffi_cif *cif, void(*fn)(void), void *rvalue, void **values,
+		int *sig, uintptr_t *address) {
+
+	sigjmp_buf jump;
+	if (sigsetjmp(jump, 1) != 0) {
+		*sig = _guard_signal;
+		*address = _guard_address;
+		return -1;
+	}
+
+	_guard_jump = &jump;
+	ffi_call(cif, fn, rvalue, values);
+	_guard_jump = NULL;
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// NativeFaultError is returned by guarded functions (see Guarded), when the
+// native code raised a SIGSEGV, SIGBUS or SIGFPE signal.
+type NativeFaultError struct {
+	// Symbol is the name of the called function.
+	Symbol string
+
+	// Signal is the signal raised by the native code.
+	Signal syscall.Signal
+
+	// Address is the faulting memory address (or instruction address for
+	// SIGFPE), as reported by the operating system.
+	Address uintptr
+}
+
+func (e *NativeFaultError) Error() string {
+	return fmt.Sprintf("native fault calling %s: %s at address 0x%x", e.Symbol, e.Signal, e.Address)
+}
+
+// Guarded installs signal handlers around calls to the imported function, which
+// convert a SIGSEGV, SIGBUS or SIGFPE signal raised on the calling thread into a
+// NativeFaultError, instead of terminating the process.
+// Signals raised outside of guarded calls are forwarded to the previously
+// installed handlers (usually the handlers of the Go runtime).
+// Attention: The native call is aborted using siglongjmp, without any cleanup.
+// Locks held or memory allocated by the native code at the time of the fault are
+// never released, and the state of the library may be corrupted. Guarded calls are
+// meant for robustness tests and diagnostics, not for continuing to use a library
+// after a fault. Consider using an IsolatedLibrary instead.
+func Guarded() ImportOption {
+	return func(config *importConfig) {
+		config.guarded = true
+	}
+}
+
+func guardedCall(sig *signature, cif *C.ffi_cif, funcPtr functionPointer, rvalue unsafe.Pointer,
+	cargs *unsafe.Pointer) error {
+
+	C._guard_init()
+
+	var signal C.int
+	var address C.uintptr_t
+	if C._guarded_ffi_call(cif, funcPtr, rvalue, cargs, &signal, &address) != 0 {
+		return &NativeFaultError{Symbol: sig.symbol, Signal: syscall.Signal(signal), Address: uintptr(address)}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestGuardedCall(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var crash func(int32) (int32, error)
+	if err := l.Import("_crash", &crash, Guarded()); err != nil {
+		t.Errorf("Symbol _crash failed to be imported: %v", err)
+		return
+	}
+
+	var fault *NativeFaultError
+	_, err = crash(1)
+	if !errors.As(err, &fault) || fault.Symbol != "_crash" || fault.Signal != syscall.SIGSEGV || fault.Address != 0 {
+		t.Errorf("expected SIGSEGV fault, got %v", err)
+	}
+
+	// the thread is still usable after a fault
+	_, err = crash(2)
+	if !errors.As(err, &fault) || fault.Signal != syscall.SIGSEGV {
+		t.Errorf("expected SIGSEGV fault, got %v", err)
+	}
+
+	var div func(int32, int32) (int32, int32, error)
+	if err := l.Import("_div", &div, OutParams(), Guarded()); err != nil {
+		t.Errorf("Symbol _div failed to be imported: %v", err)
+		return
+	}
+
+	if q, r, err := div(7, 2); err != nil || q != 3 || r != 1 {
+		t.Errorf("expected 3 remainder 1, got %d remainder %d (%v)", q, r, err)
+	}
+
+	_, _, err = div(7, 0)
+	if !errors.As(err, &fault) || fault.Signal != syscall.SIGFPE {
+		t.Errorf("expected SIGFPE fault, got %v", err)
+	}
+}
+
+func TestGuardedGoFault(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var crash func(int32) (int32, error)
+	if err := l.Import("_crash", &crash, Guarded()); err != nil {
+		t.Errorf("Symbol _crash failed to be imported: %v", err)
+		return
+	}
+	crash(1)
+
+	// faults in Go code are still handled by the Go runtime
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("expected a nil pointer dereference panic")
+		}
+	}()
+	var ptr *int
+	*ptr = 1
+}
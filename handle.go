@@ -0,0 +1,176 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// ErrHandleClosed is returned when a Handle is used or closed after
+// it was already closed.
+var ErrHandleClosed = errors.New("handle is already closed")
+
+// TypeHandle represents a Go *Handle. This type is
+// translated into a void* type in C.
+var TypeHandle = reflect.TypeOf((*Handle)(nil))
+
+// Handle wraps an opaque native pointer, such as sqlite3* or FILE*,
+// returned by an imported function. A Handle can be bound to a native
+// destructor (see Destructor), which is called when the Handle is
+// closed, or when it becomes unreachable and is collected by the Go
+// runtime.
+// Passing a closed Handle to an imported function fails with
+// ErrHandleClosed, instead of passing a dangling pointer. Closing a
+// Handle waits for running calls, which the Handle was passed to.
+type Handle struct {
+	m          sync.Mutex
+	released   *sync.Cond
+	ptr        uintptr
+	uses       int
+	closed     bool
+	destructor func(uintptr)
+}
+
+// NewHandle wraps the given native pointer into a Handle, bound to
+// the given destructor function. The destructor may be nil, in which
+// case closing the Handle only invalidates it.
+func NewHandle(ptr uintptr, destructor func(uintptr)) *Handle {
+	h := &Handle{
+		ptr:        ptr,
+		destructor: destructor,
+	}
+	h.released = sync.NewCond(&h.m)
+	runtime.SetFinalizer(h, (*Handle).Close)
+	return h
+}
+
+// Pointer returns the native pointer of the Handle, or ErrHandleClosed
+// if the Handle is already closed.
+func (h *Handle) Pointer() (uintptr, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.closed {
+		return 0, ErrHandleClosed
+	}
+	return h.ptr, nil
+}
+
+// IsClosed returns true if the Handle is already closed.
+func (h *Handle) IsClosed() bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return h.closed
+}
+
+// Close invalidates the Handle and calls the bound destructor, if
+// any, after all running calls using the Handle returned. Closing an
+// already closed Handle returns ErrHandleClosed.
+func (h *Handle) Close() error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.closed {
+		return ErrHandleClosed
+	}
+
+	h.closed = true
+	for h.uses > 0 {
+		h.released.Wait()
+	}
+	runtime.SetFinalizer(h, nil)
+	if h.destructor != nil {
+		h.destructor(h.ptr)
+	}
+	h.ptr = 0
+	return nil
+}
+
+// acquire returns the native pointer of the Handle and prevents the
+// Handle from being destroyed, until release is called
+func (h *Handle) acquire() (uintptr, error) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.closed {
+		return 0, ErrHandleClosed
+	}
+	h.uses++
+	return h.ptr, nil
+}
+
+func (h *Handle) release() {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.uses--
+	if h.uses == 0 {
+		h.released.Broadcast()
+	}
+}
+
+// Destructor binds the native function, exported by the same library
+// under the given symbol, as the destructor of Handles returned by
+// the imported function. The destructor is expected to take the
+// native pointer as its only parameter, e.g. void free(void*).
+// After the library was closed, the destructor isn't called anymore,
+// neither when closing a Handle nor when it is collected.
+func Destructor(symbol string) ImportOption {
+	return func(config *importConfig) {
+		config.destructor = symbol
+	}
+}
+
+func (l *Library) importDestructor(symbol string) (func(uintptr), error) {
+	if symbol == "" {
+		return nil, nil
+	}
+
+	var destructor func(uintptr)
+	if err := l.Import(symbol, &destructor); err != nil {
+		return nil, err
+	}
+
+	// the library must not be closed while the destructor runs
+	return func(ptr uintptr) {
+		l.state.RLock()
+		defer l.state.RUnlock()
+		if !l.closed {
+			destructor(ptr)
+		}
+	}, nil
+}
+
+func handleValue(value reflect.Value) (reflect.Value, func(), error) {
+	h := value.Interface().(*Handle)
+	if h == nil {
+		return reflect.ValueOf(uintptr(0)), nil, nil
+	}
+
+	ptr, err := h.acquire()
+	if err != nil {
+		return value, nil, err
+	}
+	return reflect.ValueOf(ptr), h.release, nil
+}
+
+func makeHandle(value reflect.Value, destructor func(uintptr)) reflect.Value {
+	ptr := uintptr(reflect.Indirect(value).Uint())
+	if ptr == 0 {
+		return reflect.Zero(TypeHandle)
+	}
+	return reflect.ValueOf(NewHandle(ptr, destructor))
+}
@@ -0,0 +1,212 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHandleDestructor(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var newHandle func(int32) *Handle
+	if err := l.Import("_handle_new", &newHandle, Destructor("_handle_free")); err != nil {
+		t.Errorf("Symbol _handle_new failed to be imported: %v", err)
+		return
+	}
+
+	var get func(*Handle) (int32, error)
+	if err := l.Import("_handle_get", &get); err != nil {
+		t.Errorf("Symbol _handle_get failed to be imported: %v", err)
+		return
+	}
+
+	var freed func() int32
+	if err := l.Import("_handles_freed", &freed); err != nil {
+		t.Errorf("Symbol _handles_freed failed to be imported: %v", err)
+		return
+	}
+
+	h := newHandle(42)
+	v, err := get(h)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if v != 42 {
+		t.Errorf("expected 42, got %d", v)
+	}
+
+	before := freed()
+	if err := h.Close(); err != nil {
+		t.Errorf("Handle failed to be closed: %v", err)
+	}
+	if freed() != before+1 {
+		t.Error("destructor wasn't called")
+	}
+
+	if err := h.Close(); err != ErrHandleClosed {
+		t.Errorf("expected ErrHandleClosed when closing twice, got: %v", err)
+	}
+
+	if _, err := get(h); err != ErrHandleClosed {
+		t.Errorf("expected ErrHandleClosed when using closed handle, got: %v", err)
+	}
+}
+
+func TestHandleDestructorNotFound(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var newHandle func(int32) *Handle
+	if err := l.Import("_handle_new", &newHandle, Destructor("_handle_free123")); err == nil {
+		t.Error("the import should fail due to a missing destructor")
+	}
+}
+
+func TestSelfHandle(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var newHandle func(int32) *Handle
+	if err := l.Import("_handle_new", &newHandle, Destructor("_handle_free")); err != nil {
+		t.Errorf("Symbol _handle_new failed to be imported: %v", err)
+		return
+	}
+
+	h := newHandle(42)
+	defer h.Close()
+
+	var api struct {
+		Get func() (int32, error) `goffi:"_handle_get"`
+	}
+	if err := l.ImportAll(&api, Self(h)); err != nil {
+		t.Errorf("Symbol _handle_get failed to be imported: %v", err)
+		return
+	}
+
+	v, err := api.Get()
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if v != 42 {
+		t.Errorf("expected 42, got %d", v)
+	}
+
+	h.Close()
+	if _, err := api.Get(); err != ErrHandleClosed {
+		t.Errorf("expected ErrHandleClosed when using closed self handle, got: %v", err)
+	}
+}
+
+func TestSelfHandleOutParams(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var newHandle func(int32) *Handle
+	if err := l.Import("_handle_new", &newHandle, Destructor("_handle_free")); err != nil {
+		t.Errorf("Symbol _handle_new failed to be imported: %v", err)
+		return
+	}
+
+	h := newHandle(21)
+	defer h.Close()
+
+	var scaled func(int32) (int32, int32, error)
+	if err := l.Import("_handle_get_scaled", &scaled, Self(h), OutParams()); err != nil {
+		t.Errorf("Symbol _handle_get_scaled failed to be imported: %v", err)
+		return
+	}
+
+	v, s, err := scaled(2)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if v != 21 || s != 42 {
+		t.Errorf("expected 21 and 42, got %d and %d", v, s)
+	}
+}
+
+func TestHandleCloseWaitsForCalls(t *testing.T) {
+	h := NewHandle(1, nil)
+	if _, err := h.acquire(); err != nil {
+		t.Errorf("Handle failed to be acquired: %v", err)
+		return
+	}
+
+	closed := make(chan error)
+	go func() {
+		closed <- h.Close()
+	}()
+
+	select {
+	case <-closed:
+		t.Error("Handle was closed while in use")
+		return
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	h.release()
+	if err := <-closed; err != nil {
+		t.Errorf("Handle failed to be closed: %v", err)
+	}
+	if _, err := h.acquire(); err != ErrHandleClosed {
+		t.Errorf("expected ErrHandleClosed when acquiring closed handle, got: %v", err)
+	}
+}
+
+func TestHandleAfterLibraryClose(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+
+	var newHandle func(int32) *Handle
+	if err := l.Import("_handle_new", &newHandle, Destructor("_handle_free")); err != nil {
+		t.Errorf("Symbol _handle_new failed to be imported: %v", err)
+		return
+	}
+
+	h := newHandle(42)
+	if err := l.Close(); err != nil {
+		t.Errorf("Library failed to be closed: %v", err)
+	}
+
+	// the destructor must not be called into the closed library
+	if err := h.Close(); err != nil {
+		t.Errorf("Handle failed to be closed: %v", err)
+	}
+}
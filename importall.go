@@ -0,0 +1,155 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+const importTag = "goffi"
+
+var errNoStructPointer = errors.New("target not a pointer to a struct")
+
+// ImportError describes a single failed import of ImportAll.
+type ImportError struct {
+	Field  string
+	Symbol string
+	Err    error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("field %s (symbol %s): %v", e.Field, e.Symbol, e.Err)
+}
+
+// ImportErrors aggregates all failed imports of ImportAll.
+type ImportErrors []*ImportError
+
+func (e ImportErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d symbol(s) failed to be imported: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// ImportAll imports a symbol for every exported field of function type
+// in the struct the given api pointer points to. The symbol name is
+// defined by the field's goffi tag and defaults to the snake case
+// representation of the field name (e.g. GetPid imports get_pid).
+//
+//	type API struct {
+//		Sqrt     func(float64) float64 `goffi:"sqrt"`
+//		Optional func() int32          `goffi:"maybe_there,optional"`
+//		Ignored  func()                `goffi:"-"`
+//	}
+//
+// Fields tagged as optional are left nil, if the symbol isn't exported
+// by the library. Fields of embedded structs are imported as well. The
+// given options apply to all imported fields.
+// All failed imports are collected and returned as ImportErrors.
+func (l *Library) ImportAll(api interface{}, options ...ImportOption) error {
+	v := reflect.ValueOf(api)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return errNoStructPointer
+	}
+
+	errs := l.importStruct(v.Elem(), options, nil)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (l *Library) importStruct(v reflect.Value, options []ImportOption, errs ImportErrors) ImportErrors {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fv := v.Field(i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			errs = l.importStruct(fv, options, errs)
+			continue
+		}
+
+		if field.Type.Kind() != reflect.Func || !fv.CanSet() {
+			continue
+		}
+
+		symbol, optional, skip := parseImportTag(field)
+		if skip {
+			continue
+		}
+
+		if optional {
+			if _, err := l.Symbol(symbol); err != nil {
+				continue
+			}
+		}
+
+		if err := l.Import(symbol, fv.Addr().Interface(), options...); err != nil {
+			errs = append(errs, &ImportError{Field: field.Name, Symbol: symbol, Err: err})
+		}
+	}
+	return errs
+}
+
+func parseImportTag(field reflect.StructField) (string, bool, bool) {
+	tag, ok := field.Tag.Lookup(importTag)
+	if !ok {
+		return snakeCase(field.Name), false, false
+	}
+
+	if tag == "-" {
+		return "", false, true
+	}
+
+	parts := strings.Split(tag, ",")
+	symbol := parts[0]
+	if symbol == "" {
+		symbol = snakeCase(field.Name)
+	}
+
+	optional := false
+	for _, p := range parts[1:] {
+		if p == "optional" {
+			optional = true
+		}
+	}
+	return symbol, optional, false
+}
+
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			// split before an upper case character, which either follows a lower case
+			// character or digit, or starts a new word after an acronym (e.g. HTTPServer)
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+				b.WriteRune('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
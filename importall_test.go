@@ -0,0 +1,89 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"testing"
+)
+
+type testAPI struct {
+	GetAnswer func() int32
+	Sqrt      func(float64) float64 `goffi:"_sqrt"`
+	Optional  func() int32          `goffi:"_not_existing,optional"`
+	Ignored   func() int32          `goffi:"-"`
+	Missing   func() int32          `goffi:"_missing"`
+	Other     string
+}
+
+func TestImportAll(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var api testAPI
+	err = l.ImportAll(&api)
+
+	errs, ok := err.(ImportErrors)
+	if !ok || len(errs) != 1 {
+		t.Errorf("expected exactly one import error, got: %v", err)
+	} else if errs[0].Field != "Missing" || errs[0].Symbol != "_missing" {
+		t.Errorf("unexpected import error: %v", errs[0])
+	}
+
+	if api.GetAnswer == nil || api.GetAnswer() != 42 {
+		t.Error("GetAnswer wasn't imported as get_answer")
+	}
+	if api.Sqrt == nil || api.Sqrt(9.) != 3. {
+		t.Error("Sqrt wasn't imported as _sqrt")
+	}
+	if api.Optional != nil || api.Ignored != nil {
+		t.Error("optional or ignored fields shouldn't be imported")
+	}
+}
+
+func TestImportAllNoStruct(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var fn func()
+	if err := l.ImportAll(&fn); err != errNoStructPointer {
+		t.Errorf("expected errNoStructPointer, got: %v", err)
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	cases := map[string]string{
+		"GetPid":        "get_pid",
+		"Open":          "open",
+		"HTTPServer":    "http_server",
+		"Sqlite3Open":   "sqlite3_open",
+		"alreadySnaked": "already_snaked",
+	}
+	for name, expected := range cases {
+		if s := snakeCase(name); s != expected {
+			t.Errorf("expected '%s' for '%s', got '%s'", expected, name, s)
+		}
+	}
+}
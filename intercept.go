@@ -0,0 +1,208 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// CallInfo describes a single call to an imported function, as passed
+// to an Interceptor.
+type CallInfo struct {
+	// Symbol is the name of the called C function.
+	Symbol string
+
+	// Args are the Go argument values, excluding a context and a scope.
+	Args []interface{}
+
+	// Results are the Go return values, excluding the error. Results
+	// are only set after the call returned.
+	Results []interface{}
+
+	// Err is the error returned by the call, or a panic raised by it.
+	Err error
+
+	// Start is the time the call started.
+	Start time.Time
+
+	// Duration is the time the call took, only set after the call returned.
+	Duration time.Duration
+}
+
+// Interceptor is called before and after every call to functions of a
+// Library it is registered with (see Library.AddInterceptor).
+// Interceptors are called from the calling goroutine and must be safe
+// for concurrent use.
+type Interceptor interface {
+	// Before is called before the native call is executed.
+	Before(call *CallInfo)
+
+	// After is called after the native call returned.
+	After(call *CallInfo)
+}
+
+// InterceptorFuncs implements Interceptor using optional functions.
+type InterceptorFuncs struct {
+	// BeforeFunc is called before the native call, if set.
+	BeforeFunc func(call *CallInfo)
+
+	// AfterFunc is called after the native call, if set.
+	AfterFunc func(call *CallInfo)
+}
+
+// Before calls BeforeFunc, if set.
+func (i *InterceptorFuncs) Before(call *CallInfo) {
+	if i.BeforeFunc != nil {
+		i.BeforeFunc(call)
+	}
+}
+
+// After calls AfterFunc, if set.
+func (i *InterceptorFuncs) After(call *CallInfo) {
+	if i.AfterFunc != nil {
+		i.AfterFunc(call)
+	}
+}
+
+// NewLogInterceptor returns an Interceptor, which logs every call with its
+// arguments, results, error and duration to the given logger, using a
+// key=value format. If logger is nil, the standard logger is used.
+func NewLogInterceptor(logger *log.Logger) Interceptor {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &InterceptorFuncs{
+		AfterFunc: func(call *CallInfo) {
+			logger.Print(call.String())
+		},
+	}
+}
+
+// String returns a key=value representation of the call.
+func (c *CallInfo) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "symbol=%s args=%s", c.Symbol, formatValues(c.Args))
+	if c.Results != nil {
+		fmt.Fprintf(&b, " results=%s", formatValues(c.Results))
+	}
+	if c.Err != nil {
+		fmt.Fprintf(&b, " error=%q", c.Err.Error())
+	}
+	if c.Duration > 0 {
+		fmt.Fprintf(&b, " duration=%s", c.Duration)
+	}
+	return b.String()
+}
+
+// AddInterceptor registers the given interceptors with the Library. They are
+// called for all functions imported from the Library, including already
+// imported functions, in the order they were added (and reverse order after
+// the call).
+func (l *Library) AddInterceptor(interceptors ...Interceptor) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	current := l.interceptors.Load().([]Interceptor)
+	updated := make([]Interceptor, 0, len(current)+len(interceptors))
+	updated = append(updated, current...)
+	l.interceptors.Store(append(updated, interceptors...))
+}
+
+// RemoveInterceptor removes the given interceptor from the Library.
+func (l *Library) RemoveInterceptor(interceptor Interceptor) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	current := l.interceptors.Load().([]Interceptor)
+	updated := make([]Interceptor, 0, len(current))
+	for _, i := range current {
+		if i != interceptor {
+			updated = append(updated, i)
+		}
+	}
+	l.interceptors.Store(updated)
+}
+
+func (l *Library) interceptStub(symbol string, sig *signature, hasContext bool, stub stubFunc) stubFunc {
+	return func(values []reflect.Value) []reflect.Value {
+		interceptors := l.interceptors.Load().([]Interceptor)
+		if len(interceptors) == 0 {
+			return stub(values)
+		}
+
+		args := values
+		if hasContext {
+			args = args[1:]
+		}
+		if sig.scoped {
+			args = args[1:]
+		}
+
+		call := &CallInfo{Symbol: symbol, Args: interfaceValues(args), Start: time.Now()}
+		for _, i := range interceptors {
+			i.Before(call)
+		}
+
+		after := func() {
+			call.Duration = time.Since(call.Start)
+			for i := len(interceptors) - 1; i >= 0; i-- {
+				interceptors[i].After(call)
+			}
+		}
+
+		defer func() {
+			if p := recover(); p != nil {
+				if err, ok := p.(error); ok {
+					call.Err = err
+				} else {
+					call.Err = fmt.Errorf("panic: %v", p)
+				}
+				after()
+				panic(p)
+			}
+		}()
+
+		results := stub(values)
+		call.Results = interfaceValues(results)
+		if sig.returnsError {
+			call.Results = call.Results[:len(results)-1]
+			call.Err, _ = results[len(results)-1].Interface().(error)
+		}
+		after()
+		return results
+	}
+}
+
+func interfaceValues(values []reflect.Value) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		result[i] = value.Interface()
+	}
+	return result
+}
+
+func formatValues(values []interface{}) string {
+	formatted := make([]string, len(values))
+	for i, value := range values {
+		formatted[i] = fmt.Sprintf("%#v", value)
+	}
+	return "[" + strings.Join(formatted, " ") + "]"
+}
@@ -0,0 +1,128 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterceptor(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var sint32 func(int32) int32
+	if err := l.Import("__sint32", &sint32); err != nil {
+		t.Errorf("Symbol __sint32 failed to be imported: %v", err)
+		return
+	}
+
+	var before, after []*CallInfo
+	interceptor := &InterceptorFuncs{
+		BeforeFunc: func(call *CallInfo) {
+			before = append(before, call)
+		},
+		AfterFunc: func(call *CallInfo) {
+			after = append(after, call)
+		},
+	}
+	l.AddInterceptor(interceptor)
+
+	sint32(63)
+	if len(before) != 1 || len(after) != 1 {
+		t.Errorf("expected one intercepted call, got %d/%d", len(before), len(after))
+		return
+	}
+
+	call := after[0]
+	if call.Symbol != "__sint32" || !reflect.DeepEqual(call.Args, []interface{}{int32(63)}) ||
+		!reflect.DeepEqual(call.Results, []interface{}{int32(31)}) || call.Err != nil {
+		t.Errorf("unexpected call: %s", call)
+	}
+
+	l.RemoveInterceptor(interceptor)
+	sint32(63)
+	if len(after) != 1 {
+		t.Errorf("removed interceptor was called")
+	}
+}
+
+func TestInterceptorScope(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var sint32 func(context.Context, *Scope, int32) (int32, error)
+	if err := l.Import("__sint32", &sint32); err != nil {
+		t.Errorf("Symbol __sint32 failed to be imported: %v", err)
+		return
+	}
+
+	var calls []*CallInfo
+	l.AddInterceptor(&InterceptorFuncs{
+		AfterFunc: func(call *CallInfo) {
+			calls = append(calls, call)
+		},
+	})
+
+	scope := NewScope()
+	defer scope.Close()
+
+	sint32(context.Background(), scope, 63)
+	if len(calls) != 1 || !reflect.DeepEqual(calls[0].Args, []interface{}{int32(63)}) {
+		t.Errorf("expected context and scope to be excluded, got %v", calls)
+	}
+}
+
+func TestInterceptorError(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var get func(*Handle) (int32, error)
+	if err := l.Import("_handle_get", &get); err != nil {
+		t.Errorf("Symbol _handle_get failed to be imported: %v", err)
+		return
+	}
+
+	var buffer bytes.Buffer
+	l.AddInterceptor(NewLogInterceptor(log.New(&buffer, "", 0)))
+
+	h := NewHandle(0, nil)
+	h.Close()
+	get(h)
+
+	line := buffer.String()
+	if !strings.HasPrefix(line, "symbol=_handle_get args=") || !strings.Contains(line, "results=[0]") ||
+		!strings.Contains(line, "error=\"handle is already closed\"") {
+		t.Errorf("unexpected log output: %s", line)
+	}
+}
@@ -0,0 +1,493 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"github.com/achille-roussel/go-dl"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+const (
+	isolatedHelperEnv = "GOFFI_ISOLATED_HELPER"
+	isolatedModeEnv   = "GOFFI_ISOLATED_MODE"
+)
+
+var (
+	errIsolatedLibraryClosed = errors.New("isolated library is already closed")
+	errIsolatedTypeSupported = errors.New("isolated functions only support numeric, bool and string types")
+	errIsolatedHelperMissing = errors.New("isolated helper process didn't call RunIsolatedHelper")
+)
+
+var isolatedTypes = map[reflect.Kind]reflect.Type{
+	reflect.Bool:    TypeBool,
+	reflect.Int:     TypeInt,
+	reflect.Int8:    TypeInt8,
+	reflect.Int16:   TypeInt16,
+	reflect.Int32:   TypeInt32,
+	reflect.Int64:   TypeInt64,
+	reflect.Uint:    TypeUint,
+	reflect.Uint8:   TypeUint8,
+	reflect.Uint16:  TypeUint16,
+	reflect.Uint32:  TypeUint32,
+	reflect.Uint64:  TypeUint64,
+	reflect.Float32: TypeFloat32,
+	reflect.Float64: TypeFloat64,
+	reflect.String:  TypeString,
+}
+
+var signalPattern = regexp.MustCompile(`^(?:\[signal )?(SIG[A-Z]+)[: ]`)
+
+var signalNames = map[string]syscall.Signal{
+	"SIGSEGV": syscall.SIGSEGV,
+	"SIGBUS":  syscall.SIGBUS,
+	"SIGFPE":  syscall.SIGFPE,
+	"SIGILL":  syscall.SIGILL,
+	"SIGABRT": syscall.SIGABRT,
+}
+
+// HelperCrashError is returned by calls to an IsolatedLibrary, when the helper
+// process crashed while executing the call.
+type HelperCrashError struct {
+	// Symbol is the name of the called function.
+	Symbol string
+
+	// Signal is the signal which caused the crash, or zero if unknown.
+	Signal syscall.Signal
+
+	// ExitCode is the exit code of the helper process, or -1 if it was
+	// killed by a signal.
+	ExitCode int
+
+	// Output contains the last part of the helper's standard error output.
+	Output string
+}
+
+func (e *HelperCrashError) Error() string {
+	if e.Signal != 0 {
+		return fmt.Sprintf("helper process crashed calling %s: %s", e.Symbol, e.Signal)
+	}
+	return fmt.Sprintf("helper process crashed calling %s: exit code %d", e.Symbol, e.ExitCode)
+}
+
+// IsolatedLibrary represents a library, which is loaded into a separate helper
+// process. Calls to imported functions are sent to the helper process, so that
+// a crash of the native code (e.g. a segmentation fault) doesn't terminate the
+// calling process, but returns a HelperCrashError. The helper process is restarted
+// on the next call.
+// The helper process is the current executable, started again in a special helper
+// mode. The executable must call RunIsolatedHelper at the top of its main function.
+// Only numeric, bool and string parameter and return types are supported, since
+// memory isn't shared with the helper process. Calls are executed one at a time.
+type IsolatedLibrary struct {
+	m        sync.Mutex
+	name     string
+	mode     Mode
+	helper   *isolatedHelper
+	restarts int
+	closed   bool
+}
+
+type isolatedHelper struct {
+	cmd    *exec.Cmd
+	out    *os.File
+	enc    *gob.Encoder
+	dec    *gob.Decoder
+	stderr *helperOutput
+}
+
+type isolatedRequest struct {
+	Symbol string
+	In     []reflect.Kind
+	Out    reflect.Kind
+	Args   []interface{}
+	Import bool
+}
+
+type isolatedResponse struct {
+	Result interface{}
+	Err    string
+}
+
+// NewIsolatedLibrary starts a helper process, which loads the given library.
+// The library is resolved the same way as by NewLibrary.
+func NewIsolatedLibrary(library string, mode Mode) (*IsolatedLibrary, error) {
+	path, err := dl.Find(library)
+	if err != nil {
+		return nil, err
+	}
+
+	// a helper process, which wasn't taken over by RunIsolatedHelper, must
+	// not start helper processes on its own
+	if os.Getenv(isolatedHelperEnv) != "" {
+		return nil, errIsolatedHelperMissing
+	}
+
+	l := &IsolatedLibrary{name: path, mode: mode}
+	if l.helper, err = l.startHelper(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Path returns the resolved path of the library file.
+func (l *IsolatedLibrary) Path() string {
+	return l.name
+}
+
+// Restarts returns the number of times the helper process was restarted
+// after a crash.
+func (l *IsolatedLibrary) Restarts() int {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.restarts
+}
+
+// Import imports a symbol from the isolated library, like Library.Import. The
+// given target must be a pointer to a function variable in Go, with numeric,
+// bool or string parameters and an optional return value of the same types,
+// optionally followed by an error. If the function doesn't return an error,
+// a crash of the helper process raises a panic.
+func (l *IsolatedLibrary) Import(symbol string, target interface{}) error {
+	tpt := reflect.TypeOf(target)
+	if tpt.Kind() != reflect.Ptr || tpt.Elem().Kind() != reflect.Func {
+		return errors.New("target not a function type")
+	}
+	fnType := tpt.Elem()
+
+	returnsError, err := precheckResultTypes(fnType, &importConfig{})
+	if err != nil {
+		return err
+	}
+
+	request := isolatedRequest{Symbol: symbol, In: make([]reflect.Kind, fnType.NumIn()), Out: reflect.Invalid}
+	for i := range request.In {
+		if request.In[i], err = isolatedKind(fnType.In(i)); err != nil {
+			return err
+		}
+	}
+	if numResultValues(fnType, returnsError) == 1 {
+		if request.Out, err = isolatedKind(fnType.Out(0)); err != nil {
+			return err
+		}
+	}
+
+	// imports the function in the helper process, to fail early
+	importRequest := request
+	importRequest.Import = true
+	if _, err := l.call(importRequest); err != nil {
+		return err
+	}
+
+	stub := func(values []reflect.Value) []reflect.Value {
+		call := request
+		call.Args = make([]interface{}, len(values))
+		for i, value := range values {
+			call.Args[i] = value.Convert(isolatedTypes[request.In[i]]).Interface()
+		}
+
+		results := make([]reflect.Value, 0, fnType.NumOut())
+		result, err := l.call(call)
+		if err != nil {
+			if !returnsError {
+				panic(err)
+			}
+			return errorResults(fnType, err)
+		}
+
+		if request.Out != reflect.Invalid {
+			results = append(results, reflect.ValueOf(result).Convert(fnType.Out(0)))
+		}
+		if returnsError {
+			results = append(results, valueNilError)
+		}
+		return results
+	}
+
+	reflect.ValueOf(target).Elem().Set(reflect.MakeFunc(fnType, stub))
+	return nil
+}
+
+// Close stops the helper process, which unloads the library.
+func (l *IsolatedLibrary) Close() error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.closed {
+		return errIsolatedLibraryClosed
+	}
+
+	l.closed = true
+	if l.helper != nil {
+		l.helper.stop()
+		l.helper = nil
+	}
+	return nil
+}
+
+func (l *IsolatedLibrary) call(request isolatedRequest) (interface{}, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.closed {
+		return nil, errIsolatedLibraryClosed
+	}
+
+	if l.helper == nil {
+		helper, err := l.startHelper()
+		if err != nil {
+			return nil, err
+		}
+		l.helper = helper
+		l.restarts++
+	}
+
+	var response isolatedResponse
+	err := l.helper.enc.Encode(&request)
+	if err == nil {
+		err = l.helper.dec.Decode(&response)
+	}
+	if err != nil {
+		crash := l.helper.crashed(request.Symbol)
+		l.helper = nil
+		return nil, crash
+	}
+
+	if response.Err != "" {
+		return nil, errors.New(response.Err)
+	}
+	return response.Result, nil
+}
+
+func (l *IsolatedLibrary) startHelper() (*isolatedHelper, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	requestReader, requestWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	responseReader, responseWriter, err := os.Pipe()
+	if err != nil {
+		requestReader.Close()
+		requestWriter.Close()
+		return nil, err
+	}
+
+	helper := &isolatedHelper{
+		cmd:    exec.Command(executable),
+		out:    requestWriter,
+		enc:    gob.NewEncoder(requestWriter),
+		dec:    gob.NewDecoder(responseReader),
+		stderr: &helperOutput{limit: 4096},
+	}
+
+	helper.cmd.Env = append(os.Environ(),
+		isolatedHelperEnv+"="+l.name,
+		isolatedModeEnv+"="+strconv.Itoa(int(l.mode)),
+	)
+	helper.cmd.Stdout = os.Stdout
+	helper.cmd.Stderr = helper.stderr
+	helper.cmd.ExtraFiles = []*os.File{requestReader, responseWriter}
+
+	err = helper.cmd.Start()
+	requestReader.Close()
+	responseWriter.Close()
+	if err != nil {
+		requestWriter.Close()
+		responseReader.Close()
+		return nil, err
+	}
+
+	// the helper responds with the result of loading the library
+	var response isolatedResponse
+	if err := helper.dec.Decode(&response); err != nil {
+		return nil, helper.crashed("")
+	}
+	if response.Err != "" {
+		helper.stop()
+		return nil, errors.New(response.Err)
+	}
+	return helper, nil
+}
+
+func (h *isolatedHelper) stop() {
+	h.out.Close()
+	h.cmd.Wait()
+}
+
+func (h *isolatedHelper) crashed(symbol string) error {
+	h.out.Close()
+	h.cmd.Wait()
+
+	crash := &HelperCrashError{Symbol: symbol, ExitCode: h.cmd.ProcessState.ExitCode(), Output: h.stderr.String()}
+	if status, ok := h.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		crash.Signal = status.Signal()
+	} else {
+		// the Go runtime of the helper handles the signal and exits
+		crash.Signal = h.stderr.signal
+	}
+	return crash
+}
+
+func isolatedKind(t reflect.Type) (reflect.Kind, error) {
+	if _, ok := isolatedTypes[t.Kind()]; !ok {
+		return reflect.Invalid, errIsolatedTypeSupported
+	}
+	return t.Kind(), nil
+}
+
+// helperOutput keeps the last bytes of the helper's error output, and
+// the last signal reported by the Go runtime of the helper
+type helperOutput struct {
+	m      sync.Mutex
+	limit  int
+	data   []byte
+	line   []byte
+	signal syscall.Signal
+}
+
+func (o *helperOutput) Write(p []byte) (int, error) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	o.data = append(o.data, p...)
+	if len(o.data) > o.limit {
+		o.data = o.data[len(o.data)-o.limit:]
+	}
+
+	for _, c := range p {
+		if c != '\n' {
+			if len(o.line) < o.limit {
+				o.line = append(o.line, c)
+			}
+			continue
+		}
+
+		if match := signalPattern.FindSubmatch(o.line); match != nil {
+			o.signal = signalNames[string(match[1])]
+		}
+		o.line = o.line[:0]
+	}
+	return len(p), nil
+}
+
+func (o *helperOutput) String() string {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return string(o.data)
+}
+
+// RunIsolatedHelper runs the helper process of an IsolatedLibrary, if the
+// current process was started as one, and exits afterwards. Otherwise it
+// returns immediately. Executables using NewIsolatedLibrary must call it at
+// the top of their main function, before any other work is done.
+func RunIsolatedHelper() {
+	if library := os.Getenv(isolatedHelperEnv); library != "" {
+		os.Exit(runIsolatedHelper(library))
+	}
+}
+
+// runIsolatedHelper is the main loop of the helper process, executing
+// the calls received from the parent process
+func runIsolatedHelper(library string) int {
+	os.Unsetenv(isolatedHelperEnv)
+	in := os.NewFile(3, "requests")
+	out := os.NewFile(4, "responses")
+	dec := gob.NewDecoder(in)
+	enc := gob.NewEncoder(out)
+
+	mode, _ := strconv.Atoi(os.Getenv(isolatedModeEnv))
+	l, err := NewLibrary(library, Mode(mode))
+	if err != nil {
+		enc.Encode(&isolatedResponse{Err: err.Error()})
+		return 1
+	}
+	if err := enc.Encode(&isolatedResponse{}); err != nil {
+		return 1
+	}
+
+	functions := make(map[string]reflect.Value)
+	for {
+		var request isolatedRequest
+		if err := dec.Decode(&request); err != nil {
+			// parent closed the connection
+			return 0
+		}
+
+		var response isolatedResponse
+		key := fmt.Sprint(request.Symbol, request.In, request.Out)
+		fn, ok := functions[key]
+		if !ok {
+			fn, err = importIsolated(l, request)
+			if err != nil {
+				response.Err = err.Error()
+				if err := enc.Encode(&response); err != nil {
+					return 1
+				}
+				continue
+			}
+			functions[key] = fn
+		}
+
+		if !request.Import {
+			args := make([]reflect.Value, len(request.Args))
+			for i, arg := range request.Args {
+				args[i] = reflect.ValueOf(arg)
+			}
+
+			results := fn.Call(args)
+			if err, _ := results[len(results)-1].Interface().(error); err != nil {
+				response.Err = err.Error()
+			} else if len(results) > 1 {
+				response.Result = results[0].Interface()
+			}
+		}
+
+		if err := enc.Encode(&response); err != nil {
+			return 1
+		}
+	}
+}
+
+func importIsolated(l *Library, request isolatedRequest) (reflect.Value, error) {
+	in := make([]reflect.Type, len(request.In))
+	for i, kind := range request.In {
+		in[i] = isolatedTypes[kind]
+	}
+
+	out := make([]reflect.Type, 0)
+	if request.Out != reflect.Invalid {
+		out = append(out, isolatedTypes[request.Out])
+	}
+
+	cFnType := reflect.FuncOf(in, out, false)
+	goFnType := reflect.FuncOf(in, append(out, TypeError), false)
+	fn, err := l.NewImportComplex(request.Symbol, goFnType, cFnType)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	return reflect.ValueOf(fn), nil
+}
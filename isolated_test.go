@@ -0,0 +1,110 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	RunIsolatedHelper()
+	os.Exit(m.Run())
+}
+
+func TestIsolatedLibrary(t *testing.T) {
+	l, err := NewIsolatedLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var sint32 func(int32) (int32, error)
+	if err := l.Import("__sint32", &sint32); err != nil {
+		t.Errorf("Symbol __sint32 failed to be imported: %v", err)
+		return
+	}
+
+	var sqrt func(float64) float64
+	if err := l.Import("_sqrt", &sqrt); err != nil {
+		t.Errorf("Symbol _sqrt failed to be imported: %v", err)
+		return
+	}
+
+	if v, err := sint32(63); err != nil || v != 31 {
+		t.Errorf("expected 31, got %d (%v)", v, err)
+	}
+	if v := sqrt(16); v != 4 {
+		t.Errorf("expected 4, got %f", v)
+	}
+
+	var missing func()
+	if err := l.Import("__missing", &missing); err == nil {
+		t.Error("importing a missing symbol should fail")
+	}
+
+	var pointer func(*int32)
+	if err := l.Import("__sint32", &pointer); err != errIsolatedTypeSupported {
+		t.Errorf("expected errIsolatedTypeSupported, got %v", err)
+	}
+}
+
+func TestIsolatedLibraryCrash(t *testing.T) {
+	l, err := NewIsolatedLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var crash func(int32) (int32, error)
+	if err := l.Import("_crash", &crash); err != nil {
+		t.Errorf("Symbol _crash failed to be imported: %v", err)
+		return
+	}
+
+	var sint32 func(int32) (int32, error)
+	if err := l.Import("__sint32", &sint32); err != nil {
+		t.Errorf("Symbol __sint32 failed to be imported: %v", err)
+		return
+	}
+
+	_, err = crash(1)
+	var crashErr *HelperCrashError
+	if !errors.As(err, &crashErr) || crashErr.Symbol != "_crash" || crashErr.Signal != syscall.SIGSEGV {
+		t.Errorf("expected SIGSEGV crash, got %v", err)
+	}
+
+	// the helper is restarted
+	if v, err := sint32(63); err != nil || v != 31 {
+		t.Errorf("expected 31, got %d (%v)", v, err)
+	}
+	if r := l.Restarts(); r != 1 {
+		t.Errorf("expected 1 restart, got %d", r)
+	}
+}
+
+func TestIsolatedLibraryInHelper(t *testing.T) {
+	t.Setenv(isolatedHelperEnv, testLibrary)
+
+	if _, err := NewIsolatedLibrary(testLibrary, BindNow); err != errIsolatedHelperMissing {
+		t.Errorf("expected errIsolatedHelperMissing, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+// LibraryInfo describes the dynamic object a Library is bound to,
+// as it was actually resolved and mapped by the dynamic loader.
+type LibraryInfo struct {
+	// Path is the resolved path of the loaded object.
+	Path string
+
+	// Soname is the SONAME (or install name on Darwin) of the object,
+	// or empty if the object doesn't define one.
+	Soname string
+
+	// Needed lists the libraries the object depends on (DT_NEEDED).
+	Needed []string
+
+	// RPath lists the DT_RPATH search paths of the object.
+	RPath []string
+
+	// RunPath lists the DT_RUNPATH search paths of the object (LC_RPATH
+	// on Darwin).
+	RunPath []string
+
+	// Base is the base address the object is mapped at.
+	Base uintptr
+}
+
+// Path returns the resolved path of the library file the Library
+// is bound to.
+func (l *Library) Path() string {
+	return l.name
+}
+
+// Info retrieves the metadata of the loaded library, such as the
+// SONAME, its dependencies, search paths and the base address of
+// the mapped object.
+func (l *Library) Info() (*LibraryInfo, error) {
+	return libraryInfo(l.name)
+}
@@ -0,0 +1,139 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+/*
+#include <mach-o/dyld.h>
+#include <limits.h>
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+static uintptr_t _library_base(const char *path) {
+	char resolved[PATH_MAX];
+	char image[PATH_MAX];
+	if (realpath(path, resolved) == NULL) {
+		return 0;
+	}
+
+	uint32_t count = _dyld_image_count();
+	for (uint32_t i = 0; i < count; i++) {
+		const char *name = _dyld_get_image_name(i);
+		if (name == NULL || realpath(name, image) == NULL) {
+			continue;
+		}
+		if (strcmp(resolved, image) == 0) {
+			return (uintptr_t)_dyld_get_image_header(i);
+		}
+	}
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"debug/macho"
+	"errors"
+	"io"
+	"runtime"
+	"unsafe"
+)
+
+const loadCmdIDDylib macho.LoadCmd = 0xd
+
+var (
+	errLibraryNotLoaded = errors.New("library is not loaded")
+	errMalformedIDDylib = errors.New("malformed LC_ID_DYLIB load command")
+)
+
+func libraryInfo(path string) (*LibraryInfo, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	base := uintptr(C._library_base(cpath))
+	if base == 0 {
+		return nil, errLibraryNotLoaded
+	}
+
+	f, closer, err := openMachO(path)
+	if err != nil {
+		return nil, err
+	}
+	defer closer.Close()
+
+	info := &LibraryInfo{
+		Path: path,
+		Base: base,
+	}
+
+	info.Needed, err = f.ImportedLibraries()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, load := range f.Loads {
+		switch l := load.(type) {
+		case *macho.Rpath:
+			info.RunPath = append(info.RunPath, l.Path)
+		case macho.LoadBytes:
+			raw := l.Raw()
+			if len(raw) < 12 || macho.LoadCmd(f.ByteOrder.Uint32(raw)) != loadCmdIDDylib {
+				continue
+			}
+			// the install name is stored at the offset, relative to the command
+			offset := f.ByteOrder.Uint32(raw[8:])
+			if offset < 12 || uint64(offset) >= uint64(len(raw)) {
+				return nil, errMalformedIDDylib
+			}
+			info.Soname = cstring(raw[offset:])
+		}
+	}
+	return info, nil
+}
+
+func openMachO(path string) (*macho.File, io.Closer, error) {
+	f, err := macho.Open(path)
+	if err == nil {
+		return f, f, nil
+	}
+
+	fat, ferr := macho.OpenFat(path)
+	if ferr != nil {
+		return nil, nil, err
+	}
+
+	cpu := macho.CpuAmd64
+	if runtime.GOARCH == "arm64" {
+		cpu = macho.CpuArm64
+	}
+	for _, arch := range fat.Arches {
+		if arch.Cpu == cpu {
+			return arch.File, fat, nil
+		}
+	}
+	fat.Close()
+	return nil, nil, err
+}
+
+func cstring(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,136 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+/*
+#define _GNU_SOURCE
+#include <dlfcn.h>
+#include <link.h>
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef struct {
+	uintptr_t base;
+	const char *name;
+	ElfW(Dyn) *dyn;
+	const char *strtab;
+} _libinfo;
+
+static int _library_info(const char *path, _libinfo *info) {
+	void *handle = dlopen(path, RTLD_LAZY | RTLD_NOLOAD);
+	if (handle == NULL) {
+		return -1;
+	}
+
+	struct link_map *lm = NULL;
+	int r = dlinfo(handle, RTLD_DI_LINKMAP, &lm);
+	if (r == 0) {
+		info->base = (uintptr_t)lm->l_addr;
+		info->name = lm->l_name;
+		info->dyn = lm->l_ld;
+		info->strtab = NULL;
+		for (ElfW(Dyn) *d = lm->l_ld; d->d_tag != DT_NULL; d++) {
+			if (d->d_tag == DT_STRTAB) {
+				uintptr_t ptr = (uintptr_t)d->d_un.d_ptr;
+				// Some architectures keep the dynamic section read-only
+				// and unrelocated
+				if (ptr < info->base) {
+					ptr += info->base;
+				}
+				info->strtab = (const char *)ptr;
+			}
+		}
+	}
+
+	// the library stays loaded by the Library instance
+	dlclose(handle);
+	return r;
+}
+
+static const char *_library_dyn_string(_libinfo *info, int index, long *tag) {
+	ElfW(Dyn) *d = &info->dyn[index];
+	*tag = (long)d->d_tag;
+	switch (d->d_tag) {
+	case DT_SONAME:
+	case DT_NEEDED:
+	case DT_RPATH:
+	case DT_RUNPATH:
+		if (info->strtab != NULL) {
+			return info->strtab + d->d_un.d_val;
+		}
+	}
+	return NULL;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"strings"
+	"unsafe"
+)
+
+var errLibraryNotLoaded = errors.New("library is not loaded")
+
+func libraryInfo(path string) (*LibraryInfo, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	var li C._libinfo
+	if C._library_info(cpath, &li) != 0 {
+		return nil, errLibraryNotLoaded
+	}
+
+	info := &LibraryInfo{
+		Path: C.GoString(li.name),
+		Base: uintptr(li.base),
+	}
+
+	for i := 0; ; i++ {
+		var tag C.long
+		str := C._library_dyn_string(&li, C.int(i), &tag)
+		if tag == C.DT_NULL {
+			break
+		}
+		if str == nil {
+			continue
+		}
+
+		value := C.GoString(str)
+		switch tag {
+		case C.DT_SONAME:
+			info.Soname = value
+		case C.DT_NEEDED:
+			info.Needed = append(info.Needed, value)
+		case C.DT_RPATH:
+			info.RPath = append(info.RPath, splitSearchPath(value)...)
+		case C.DT_RUNPATH:
+			info.RunPath = append(info.RunPath, splitSearchPath(value)...)
+		}
+	}
+	return info, nil
+}
+
+func splitSearchPath(value string) []string {
+	paths := make([]string, 0)
+	for _, p := range strings.Split(value, ":") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
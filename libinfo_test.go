@@ -0,0 +1,70 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLibraryInfo(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	info, err := l.Info()
+	if err != nil {
+		t.Errorf("Library info couldn't be retrieved: %v", err)
+		return
+	}
+
+	if !samePath(info.Path, l.Path()) {
+		t.Errorf("expected path '%s', got '%s'", l.Path(), info.Path)
+	}
+	if info.Soname != testLibrary+".so" {
+		t.Errorf("expected soname '%s.so', got '%s'", testLibrary, info.Soname)
+	}
+
+	libm := false
+	for _, needed := range info.Needed {
+		if strings.HasPrefix(needed, "libm.") {
+			libm = true
+		}
+	}
+	if !libm {
+		t.Errorf("expected libm to be a dependency, got %v", info.Needed)
+	}
+
+	s, err := l.Symbol("_sqrt")
+	if err != nil {
+		t.Errorf("Symbol _sqrt not available: %v", err)
+		return
+	}
+
+	ai, err := l.AddrInfo(s)
+	if err != nil {
+		t.Errorf("Address of _sqrt couldn't be resolved: %v", err)
+		return
+	}
+	if ai.Base != info.Base {
+		t.Errorf("expected base address 0x%x, got 0x%x", ai.Base, info.Base)
+	}
+}
@@ -0,0 +1,133 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"errors"
+	"reflect"
+)
+
+// The following marker types describe C types in the C function type passed to
+// NewImportComplex (or NewImport), for packages without access to the cgo type
+// definitions, such as C.long or C.size_t. Marker types are zero-sized and only
+// describe the C side, values are passed using ordinary Go types on the Go side,
+// which are converted to the represented C type.
+//
+//	goFnType := reflect.TypeOf((func(string) uint64)(nil))
+//	cFnType := reflect.TypeOf((func(goffi.CString) goffi.CSizeT)(nil))
+//	strlen, err := library.NewImportComplex("strlen", goFnType, cFnType)
+type (
+	// CBool describes the C _Bool / bool type.
+	CBool struct{}
+
+	// CChar describes the C char type.
+	CChar struct{}
+
+	// CShort describes the C short type.
+	CShort struct{}
+
+	// CUShort describes the C unsigned short type.
+	CUShort struct{}
+
+	// CInt describes the C int type.
+	CInt struct{}
+
+	// CUInt describes the C unsigned int type.
+	CUInt struct{}
+
+	// CLong describes the C long type.
+	CLong struct{}
+
+	// CULong describes the C unsigned long type.
+	CULong struct{}
+
+	// CLongLong describes the C long long type.
+	CLongLong struct{}
+
+	// CULongLong describes the C unsigned long long type.
+	CULongLong struct{}
+
+	// CSizeT describes the C size_t type.
+	CSizeT struct{}
+
+	// CSSizeT describes the C ssize_t type.
+	CSSizeT struct{}
+
+	// CFloat describes the C float type.
+	CFloat struct{}
+
+	// CDouble describes the C double type.
+	CDouble struct{}
+
+	// CString describes the C char* type, passed as a Go string.
+	CString struct{}
+
+	// COpaque describes an opaque C pointer (void*), passed as uintptr or
+	// unsafe.Pointer.
+	COpaque struct{}
+
+	// CFuncPtr describes a C function pointer, passed as uintptr or
+	// unsafe.Pointer.
+	CFuncPtr struct{}
+)
+
+var errMarkerType = errors.New("marker types can only be used to describe the C function type")
+
+var markerTypes = map[reflect.Type]*CType{
+	reflect.TypeOf(CBool{}):      CTypeBool,
+	reflect.TypeOf(CChar{}):      CTypeChar,
+	reflect.TypeOf(CShort{}):     CTypeShort,
+	reflect.TypeOf(CUShort{}):    CTypeUShort,
+	reflect.TypeOf(CInt{}):       CTypeInt,
+	reflect.TypeOf(CUInt{}):      CTypeUInt,
+	reflect.TypeOf(CLong{}):      CTypeLong,
+	reflect.TypeOf(CULong{}):     CTypeULong,
+	reflect.TypeOf(CLongLong{}):  CTypeLongLong,
+	reflect.TypeOf(CULongLong{}): CTypeULongLong,
+	reflect.TypeOf(CSizeT{}):     CTypeSizeT,
+	reflect.TypeOf(CSSizeT{}):    CTypeSSizeT,
+	reflect.TypeOf(CFloat{}):     CTypeFloat,
+	reflect.TypeOf(CDouble{}):    CTypeDouble,
+	reflect.TypeOf(CString{}):    CTypeString,
+	reflect.TypeOf(COpaque{}):    CTypePointer,
+	reflect.TypeOf(CFuncPtr{}):   CTypePointer,
+}
+
+func init() {
+	// marker types are resolved like any other Go type with a known C type
+	ctypes.Lock()
+	defer ctypes.Unlock()
+	for t, ct := range markerTypes {
+		ctypes.byGoType[t] = ct
+	}
+}
+
+// checkMarkerTypes fails if marker types are used on the Go side
+func checkMarkerTypes(goFnType reflect.Type) error {
+	for i := 0; i < goFnType.NumIn(); i++ {
+		if markerTypes[goFnType.In(i)] != nil {
+			return errMarkerType
+		}
+	}
+	for i := 0; i < goFnType.NumOut(); i++ {
+		if markerTypes[goFnType.Out(i)] != nil {
+			return errMarkerType
+		}
+	}
+	return nil
+}
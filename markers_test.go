@@ -0,0 +1,104 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarkerTypes(t *testing.T) {
+	l, err := NewLibrary("libc", BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	goFnType := reflect.TypeOf((func(string) uint64)(nil))
+	cFnType := reflect.TypeOf((func(CString) CSizeT)(nil))
+	fn, err := l.NewImportComplex("strlen", goFnType, cFnType)
+	if err != nil {
+		t.Errorf("Symbol strlen failed to be imported: %v", err)
+		return
+	}
+	if n := fn.(func(string) uint64)("marker"); n != 6 {
+		t.Errorf("expected 6, got %d", n)
+	}
+
+	goFnType = reflect.TypeOf((func(int) int)(nil))
+	cFnType = reflect.TypeOf((func(CLong) CLong)(nil))
+	fn, err = l.NewImportComplex("labs", goFnType, cFnType)
+	if err != nil {
+		t.Errorf("Symbol labs failed to be imported: %v", err)
+		return
+	}
+	if n := fn.(func(int) int)(-123456); n != 123456 {
+		t.Errorf("expected 123456, got %d", n)
+	}
+
+	m, err := Alloc(4)
+	if err != nil {
+		t.Errorf("allocation failed: %v", err)
+		return
+	}
+	defer m.Free()
+
+	goFnType = reflect.TypeOf((func(*Memory, int32, uint64) uintptr)(nil))
+	cFnType = reflect.TypeOf((func(COpaque, CInt, CSizeT) COpaque)(nil))
+	fn, err = l.NewImportComplex("memset", goFnType, cFnType)
+	if err != nil {
+		t.Errorf("Symbol memset failed to be imported: %v", err)
+		return
+	}
+	ptr, _ := m.Pointer()
+	if p := fn.(func(*Memory, int32, uint64) uintptr)(m, 'x', 4); p != ptr {
+		t.Errorf("expected %x, got %x", ptr, p)
+	}
+	if s := string(m.AsBytes()); s != "xxxx" {
+		t.Errorf("expected xxxx, got %s", s)
+	}
+}
+
+func TestMarkerTypesCTypeOf(t *testing.T) {
+	for marker, expected := range map[interface{}]*CType{
+		CLong{}:    CTypeLong,
+		CSizeT{}:   CTypeSizeT,
+		CString{}:  CTypeString,
+		CFuncPtr{}: CTypePointer,
+	} {
+		if ct, err := CTypeOf(reflect.TypeOf(marker)); err != nil || ct != expected {
+			t.Errorf("expected %s for %T, got %s (%v)", expected, marker, ct, err)
+		}
+	}
+}
+
+func TestMarkerTypesOnGoSide(t *testing.T) {
+	l, err := NewLibrary("libc", BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	goFnType := reflect.TypeOf((func(CString) uint64)(nil))
+	cFnType := reflect.TypeOf((func(CString) CSizeT)(nil))
+	if _, err := l.NewImportComplex("strlen", goFnType, cFnType); err != errMarkerType {
+		t.Errorf("expected errMarkerType, got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+/*
+#include <stdint.h>
+
+static void *_marshalPointer(uintptr_t ptr) {
+	return (void *)ptr;
+}
+
+static char *_marshalString(uintptr_t ptr) {
+	return (char *)ptr;
+}
+*/
+import "C"
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// CopyBack defines if scalar fields of Go memory, which was marshalled into
+// C memory for the call, are copied back after the call returns. Copying
+// back is enabled by default, it can be disabled for parameters which are
+// not modified by the C function (e.g. const struct pointers) to save the
+// cost of copying.
+func CopyBack(enabled bool) ImportOption {
+	return func(config *importConfig) {
+		config.noCopyBack = !enabled
+	}
+}
+
+// marshaller converts Go values containing strings, pointers and slices into
+// a graph of C objects, which is allocated in an arena
+type marshaller struct {
+	a       *arena
+	seen    map[seenKey]unsafe.Pointer
+	records []marshalled
+}
+
+// seenKey identifies a block of Go memory, which was already marshalled.
+// Slices sharing the same backing array may still differ in length or
+// element type.
+type seenKey struct {
+	data unsafe.Pointer
+	n    int
+	elem reflect.Type
+}
+
+// marshalled is a block of Go memory, which was copied into C memory
+type marshalled struct {
+	value reflect.Value
+	elem  *CType
+	ptr   unsafe.Pointer
+}
+
+func newMarshaller(a *arena) *marshaller {
+	return &marshaller{a: a, seen: make(map[seenKey]unsafe.Pointer)}
+}
+
+// marshal writes the C representation of the value to dst
+func (m *marshaller) marshal(value reflect.Value, ct *CType, dst unsafe.Pointer) error {
+	t := value.Type()
+	if t == ct.fieldType() && !hasGoPointers(t) {
+		reflect.NewAt(t, dst).Elem().Set(value)
+		return nil
+	}
+
+	switch ct.kind {
+	case CKindStruct:
+		for i, f := range ct.fields {
+			if err := m.marshal(value.Field(i), f.Type, unsafe.Add(dst, f.Offset)); err != nil {
+				return err
+			}
+		}
+
+	case CKindArray:
+		for i := 0; i < ct.length; i++ {
+			if err := m.marshal(value.Index(i), ct.elem, unsafe.Add(dst, uintptr(i)*ct.elem.size)); err != nil {
+				return err
+			}
+		}
+
+	case CKindUnion:
+		src := reflect.New(t)
+		src.Elem().Set(value)
+		size := ct.size
+		if t.Size() < size {
+			size = t.Size()
+		}
+		copy(unsafe.Slice((*byte)(dst), size), unsafe.Slice((*byte)(src.UnsafePointer()), size))
+
+	case CKindString:
+		*(*unsafe.Pointer)(dst) = m.a.wstring(value.String(), stringModeOf(t))
+
+	case CKindPointer:
+		ptr, err := m.reference(value, ct.elem)
+		if err != nil {
+			return err
+		}
+		*(*unsafe.Pointer)(dst) = ptr
+
+	default:
+		reflect.NewAt(ct.fieldType(), dst).Elem().Set(value.Convert(ct.fieldType()))
+	}
+	return nil
+}
+
+// reference returns the C pointer passed for a pointer, slice or address value.
+// Go memory without nested Go pointers, which matches the C memory layout, is
+// pinned and passed directly, all other memory is marshalled as elem. If elem
+// is unknown (nil or void), it is derived from the Go type.
+func (m *marshaller) reference(value reflect.Value, elem *CType) (unsafe.Pointer, error) {
+	n := 1
+	switch value.Kind() {
+	case reflect.Uintptr:
+		return C._marshalPointer(C.uintptr_t(value.Uint())), nil
+	case reflect.UnsafePointer:
+		ptr := value.UnsafePointer()
+		m.a.pin(ptr)
+		return ptr, nil
+	case reflect.Slice:
+		// string arrays are NULL terminated, even if empty
+		if value.IsNil() || value.Cap() == 0 && value.Type().Elem().Kind() != reflect.String {
+			return nil, nil
+		}
+		n = value.Len()
+	default:
+		if value.IsNil() {
+			return nil, nil
+		}
+	}
+
+	data := value.UnsafePointer()
+	key := seenKey{data: data, n: n, elem: value.Type().Elem()}
+	if ptr, ok := m.seen[key]; ok {
+		return ptr, nil
+	}
+
+	if elem == nil || elem.kind == CKindVoid {
+		var err error
+		if elem, err = CTypeOf(value.Type().Elem()); err != nil {
+			return nil, err
+		}
+	}
+
+	et := value.Type().Elem()
+	if !hasGoPointers(et) && sameLayout(et, elem) {
+		m.a.pin(data)
+		return data, nil
+	}
+
+	count := n
+	if et.Kind() == reflect.String {
+		count++
+	}
+
+	ptr := m.a.calloc(elem.size * uintptr(count))
+	m.seen[key] = ptr
+
+	original := reflect.NewAt(reflect.ArrayOf(n, et), data).Elem()
+	for i := 0; i < n; i++ {
+		if err := m.marshal(original.Index(i), elem, unsafe.Add(ptr, uintptr(i)*elem.size)); err != nil {
+			return nil, err
+		}
+	}
+	m.records = append(m.records, marshalled{value: original, elem: elem, ptr: ptr})
+	return ptr, nil
+}
+
+// copyBack copies the scalar fields of all marshalled Go memory back from
+// the C memory
+func (m *marshaller) copyBack() {
+	for _, r := range m.records {
+		for i := 0; i < r.value.Len(); i++ {
+			unmarshalScalars(r.value.Index(i), r.elem, unsafe.Add(r.ptr, uintptr(i)*r.elem.size))
+		}
+	}
+}
+
+// copyBackFunc returns the function copying back the marshalled Go memory,
+// or nil if nothing was marshalled or copying back is disabled
+func (m *marshaller) copyBackFunc(enabled bool) func() {
+	if !enabled || len(m.records) == 0 {
+		return nil
+	}
+	return m.copyBack
+}
+
+// unmarshalScalars reads the scalar fields of the value back from src.
+// Strings and pointers are never modified.
+func unmarshalScalars(value reflect.Value, ct *CType, src unsafe.Pointer) {
+	switch ct.kind {
+	case CKindStruct:
+		if value.Type() == ct.goType {
+			value.Set(reflect.NewAt(ct.goType, src).Elem())
+			return
+		}
+		for i, f := range ct.fields {
+			unmarshalScalars(value.Field(i), f.Type, unsafe.Add(src, f.Offset))
+		}
+
+	case CKindArray:
+		for i := 0; i < ct.length; i++ {
+			unmarshalScalars(value.Index(i), ct.elem, unsafe.Add(src, uintptr(i)*ct.elem.size))
+		}
+
+	case CKindSigned, CKindUnsigned, CKindFloat, CKindBool:
+		value.Set(reflect.NewAt(ct.fieldType(), src).Elem().Convert(value.Type()))
+	}
+}
+
+// sameLayout returns true if Go memory of the given type can be passed
+// to C as the given C type without conversion
+func sameLayout(t reflect.Type, ct *CType) bool {
+	switch t.Kind() {
+	case reflect.Struct:
+		if ct.kind != CKindStruct || len(ct.fields) != t.NumField() {
+			return false
+		}
+		for i, f := range ct.fields {
+			if t.Field(i).Offset != f.Offset || !sameLayout(t.Field(i).Type, f.Type) {
+				return false
+			}
+		}
+	case reflect.Array:
+		if ct.kind != CKindArray || !sameLayout(t.Elem(), ct.elem) {
+			return false
+		}
+	}
+	return t.Size() == ct.size
+}
+
+// needsMarshalling returns true if values of the given type contain Go
+// pointers, which need to be marshalled when passed by value
+func needsMarshalling(t reflect.Type) bool {
+	k := t.Kind()
+	return (k == reflect.Struct || k == reflect.Array) && hasGoPointers(t)
+}
+
+// wrapAggregate marshals a struct value containing Go pointers into
+// C memory, which is passed as the argument
+func wrapAggregate(a *arena, value reflect.Value, cType reflect.Type) (unsafe.Pointer, *marshaller, error) {
+	ct := lookupCTypeByRepr(cType)
+	if ct == nil {
+		var err error
+		if ct, err = CTypeOf(value.Type()); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	m := newMarshaller(a)
+	ptr := a.calloc(ct.size)
+	if err := m.marshal(value, ct, ptr); err != nil {
+		return nil, nil, err
+	}
+	return ptr, m, nil
+}
+
+// goStringAt copies the NUL terminated C string at the given address,
+// without freeing it
+func goStringAt(ptr uintptr) string {
+	if ptr == 0 {
+		return ""
+	}
+	return C.GoString(C._marshalString(C.uintptr_t(ptr)))
+}
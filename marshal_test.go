@@ -0,0 +1,183 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type config struct {
+	Name   string
+	Origin point
+	Values []int32
+	Count  int32
+	Offset *point
+	Total  int64
+}
+
+type node struct {
+	Label  string
+	Length int32
+	Next   *node
+}
+
+type namedLength struct {
+	Label  string
+	Length int32
+}
+
+func TestMarshalStructPointer(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var apply func(*config) (int64, error)
+	if err := l.Import("_apply_config", &apply); err != nil {
+		t.Errorf("Symbol _apply_config failed to be imported: %v", err)
+		return
+	}
+
+	cfg := &config{
+		Name:   "goffi",
+		Origin: point{X: 1, Y: 2},
+		Values: []int32{1, 2, 3},
+		Count:  3,
+		Offset: &point{X: 10, Y: 20},
+	}
+	if total, err := apply(cfg); err != nil || total != 22 {
+		t.Errorf("expected 22, got %d (%v)", total, err)
+	}
+	if cfg.Total != 22 {
+		t.Errorf("expected the total to be copied back, got %d", cfg.Total)
+	}
+	if cfg.Values[0] != 7 || cfg.Offset.Y != 99 {
+		t.Errorf("expected pinned memory to be modified, got %v and %v", cfg.Values, cfg.Offset)
+	}
+	if cfg.Name != "goffi" {
+		t.Errorf("expected the name to be unchanged, got %s", cfg.Name)
+	}
+}
+
+func TestMarshalWithoutCopyBack(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var apply func(*config) (int64, error)
+	if err := l.Import("_apply_config", &apply, CopyBack(false)); err != nil {
+		t.Errorf("Symbol _apply_config failed to be imported: %v", err)
+		return
+	}
+
+	cfg := &config{Name: "goffi", Values: []int32{1}, Count: 1, Offset: &point{}}
+	if total, err := apply(cfg); err != nil || total != 6 {
+		t.Errorf("expected 6, got %d (%v)", total, err)
+	}
+	if cfg.Total != 0 {
+		t.Errorf("expected the total not to be copied back, got %d", cfg.Total)
+	}
+}
+
+func TestMarshalStructByValue(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var length func(config) int32
+	if err := l.Import("_config_name_length", &length); err != nil {
+		t.Errorf("Symbol _config_name_length failed to be imported: %v", err)
+		return
+	}
+
+	if n := length(config{Name: "marshalled", Count: 2}); n != 12 {
+		t.Errorf("expected 12, got %d", n)
+	}
+}
+
+func TestMarshalRecursiveStruct(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var measure func(*node) int32
+	if err := l.Import("_measure_nodes", &measure); err != nil {
+		t.Errorf("Symbol _measure_nodes failed to be imported: %v", err)
+		return
+	}
+
+	list := &node{Label: "a", Next: &node{Label: "bb", Next: &node{Label: "ccc"}}}
+	if n := measure(list); n != 6 {
+		t.Errorf("expected 6, got %d", n)
+	}
+	if list.Length != 1 || list.Next.Length != 2 || list.Next.Next.Length != 3 {
+		t.Errorf("expected the lengths to be copied back")
+	}
+}
+
+func TestMarshalStructResult(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var label func() namedLength
+	if err := l.Import("_static_label", &label); err != nil {
+		t.Errorf("Symbol _static_label failed to be imported: %v", err)
+		return
+	}
+
+	if r := label(); r.Label != "static" || r.Length != 6 {
+		t.Errorf("unexpected result: %v", r)
+	}
+}
+
+func TestCTypeOfPointerFields(t *testing.T) {
+	ct, err := CTypeOf(reflect.TypeOf(config{}))
+	if err != nil {
+		t.Errorf("failed to derive C type: %v", err)
+		return
+	}
+
+	fields := ct.Fields()
+	if ct.Size() != 48 || fields[2].Offset != 16 || fields[4].Offset != 32 {
+		t.Errorf("unexpected struct layout: size %d, fields %v", ct.Size(), fields)
+	}
+
+	decl := ct.Declaration()
+	for _, field := range []string{"char *Name;", "int32_t *Values;", "struct point *Offset;"} {
+		if !strings.Contains(decl, field) {
+			t.Errorf("expected %s in declaration:\n%s", field, decl)
+		}
+	}
+}
@@ -0,0 +1,393 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+#include <string.h>
+
+static void *_pointer(uintptr_t ptr) {
+	return (void *)ptr;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+var (
+	// ErrMemoryFreed is returned when a Memory block is used or freed
+	// after it was already freed.
+	ErrMemoryFreed = errors.New("memory is already freed")
+
+	// ErrOutOfMemory is returned when the allocator failed to allocate
+	// the requested memory.
+	ErrOutOfMemory = errors.New("allocation failed")
+)
+
+var errIllegalSize = errors.New("size must not be negative or overflow")
+
+// TypeMemory represents a Go *Memory. This type is translated
+// into a void* type in C.
+var TypeMemory = reflect.TypeOf((*Memory)(nil))
+
+// Allocator allocates native memory blocks, which can be passed to C functions.
+// DefaultAllocator uses the malloc family of functions of the C library, while
+// NewAllocator binds custom allocation functions exported by a Library.
+type Allocator struct {
+	malloc  func(uintptr) unsafe.Pointer
+	calloc  func(uintptr, uintptr) unsafe.Pointer
+	realloc func(unsafe.Pointer, uintptr) unsafe.Pointer
+	free    func(unsafe.Pointer)
+}
+
+// DefaultAllocator allocates memory using malloc, calloc, realloc and free of
+// the C library.
+var DefaultAllocator = &Allocator{
+	malloc: func(size uintptr) unsafe.Pointer {
+		return C.malloc(C.size_t(size))
+	},
+	calloc: func(count, size uintptr) unsafe.Pointer {
+		return C.calloc(C.size_t(count), C.size_t(size))
+	},
+	realloc: func(ptr unsafe.Pointer, size uintptr) unsafe.Pointer {
+		return C.realloc(ptr, C.size_t(size))
+	},
+	free: func(ptr unsafe.Pointer) {
+		C.free(ptr)
+	},
+}
+
+// NewAllocator creates an Allocator using the allocation functions exported by
+// the given library under the given symbols. The functions are expected to have
+// the same signatures as their C library counterparts. Calloc and realloc are
+// optional, if empty they are emulated using malloc and free.
+func NewAllocator(library *Library, malloc, calloc, realloc, free string) (*Allocator, error) {
+	a := &Allocator{}
+
+	var mallocFn func(uintptr) uintptr
+	if err := importAllocatorFunction(library, malloc, &mallocFn, CTypeSizeT); err != nil {
+		return nil, err
+	}
+	a.malloc = func(size uintptr) unsafe.Pointer {
+		return C._pointer(C.uintptr_t(mallocFn(size)))
+	}
+
+	var freeFn func(uintptr)
+	if err := importAllocatorFunction(library, free, &freeFn, CTypePointer); err != nil {
+		return nil, err
+	}
+	a.free = func(ptr unsafe.Pointer) {
+		freeFn(uintptr(ptr))
+	}
+
+	if calloc != "" {
+		var callocFn func(uintptr, uintptr) uintptr
+		if err := importAllocatorFunction(library, calloc, &callocFn, CTypeSizeT, CTypeSizeT); err != nil {
+			return nil, err
+		}
+		a.calloc = func(count, size uintptr) unsafe.Pointer {
+			return C._pointer(C.uintptr_t(callocFn(count, size)))
+		}
+	}
+
+	if realloc != "" {
+		var reallocFn func(uintptr, uintptr) uintptr
+		if err := importAllocatorFunction(library, realloc, &reallocFn, CTypePointer, CTypeSizeT); err != nil {
+			return nil, err
+		}
+		a.realloc = func(ptr unsafe.Pointer, size uintptr) unsafe.Pointer {
+			return C._pointer(C.uintptr_t(reallocFn(uintptr(ptr), size)))
+		}
+	}
+	return a, nil
+}
+
+func importAllocatorFunction(library *Library, symbol string, target interface{}, args ...*CType) error {
+	ret := CTypePointer
+	fnType := reflect.TypeOf(target).Elem()
+	if fnType.NumOut() == 0 {
+		ret = CTypeVoid
+	}
+
+	fn, err := library.NewImportComplex(symbol, fnType, CFuncOf(ret, args...))
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(target).Elem().Set(reflect.ValueOf(fn))
+	return nil
+}
+
+// Alloc allocates an uninitialized memory block of the given size using the
+// DefaultAllocator.
+func Alloc(size int) (*Memory, error) {
+	return DefaultAllocator.Alloc(size)
+}
+
+// Calloc allocates a zeroed memory block for count elements of the given size
+// using the DefaultAllocator.
+func Calloc(count, size int) (*Memory, error) {
+	return DefaultAllocator.Calloc(count, size)
+}
+
+// Alloc allocates an uninitialized memory block of the given size.
+func (a *Allocator) Alloc(size int) (*Memory, error) {
+	if size < 0 {
+		return nil, errIllegalSize
+	}
+
+	ptr := a.malloc(allocationSize(size))
+	if ptr == nil {
+		return nil, ErrOutOfMemory
+	}
+	return &Memory{ptr: ptr, size: size, allocator: a}, nil
+}
+
+// Calloc allocates a zeroed memory block for count elements of the given size.
+func (a *Allocator) Calloc(count, size int) (*Memory, error) {
+	if count < 0 || size < 0 || size != 0 && count > math.MaxInt/size {
+		return nil, errIllegalSize
+	}
+
+	if a.calloc == nil {
+		m, err := a.Alloc(count * size)
+		if err != nil {
+			return nil, err
+		}
+		C.memset(m.ptr, 0, C.size_t(m.size))
+		return m, nil
+	}
+
+	ptr := a.calloc(allocationSize(count), allocationSize(size))
+	if ptr == nil {
+		return nil, ErrOutOfMemory
+	}
+	return &Memory{ptr: ptr, size: count * size, allocator: a}, nil
+}
+
+// allocationSize prevents zero sized allocations, which may return NULL
+func allocationSize(size int) uintptr {
+	if size == 0 {
+		return 1
+	}
+	return uintptr(size)
+}
+
+// Memory is a native memory block, allocated by an Allocator. It can be passed
+// to imported functions as a pointer parameter, and accessed from Go using typed
+// views.
+// Views (such as AsBytes) directly access the native memory and become invalid
+// when the Memory is reallocated or freed. Memory is not safe for concurrent
+// modification.
+type Memory struct {
+	ptr       unsafe.Pointer
+	size      int
+	allocator *Allocator
+}
+
+// NewMemory wraps a native memory block of the given size, e.g. returned by an
+// imported function, which was allocated by the given allocator. If the
+// allocator is nil, the memory block is never freed by the Memory.
+func NewMemory(ptr uintptr, size int, allocator *Allocator) *Memory {
+	return &Memory{ptr: C._pointer(C.uintptr_t(ptr)), size: size, allocator: allocator}
+}
+
+// Pointer returns the native address of the memory block, or ErrMemoryFreed
+// if it was already freed.
+func (m *Memory) Pointer() (uintptr, error) {
+	if m.ptr == nil {
+		return 0, ErrMemoryFreed
+	}
+	return uintptr(m.ptr), nil
+}
+
+// Size returns the size of the memory block in bytes.
+func (m *Memory) Size() int {
+	return m.size
+}
+
+// Realloc resizes the memory block, preserving its content up to the smaller
+// of the old and new size. The memory block may be moved to a new address.
+func (m *Memory) Realloc(size int) error {
+	if m.ptr == nil {
+		return ErrMemoryFreed
+	}
+	if size < 0 {
+		return errIllegalSize
+	}
+	if m.allocator == nil {
+		return fmt.Errorf("memory at %p is not owned by an allocator", m.ptr)
+	}
+
+	if m.allocator.realloc == nil {
+		ptr := m.allocator.malloc(allocationSize(size))
+		if ptr == nil {
+			return ErrOutOfMemory
+		}
+
+		n := m.size
+		if size < n {
+			n = size
+		}
+		C.memcpy(ptr, m.ptr, C.size_t(n))
+		m.allocator.free(m.ptr)
+		m.ptr, m.size = ptr, size
+		return nil
+	}
+
+	ptr := m.allocator.realloc(m.ptr, allocationSize(size))
+	if ptr == nil {
+		return ErrOutOfMemory
+	}
+	m.ptr, m.size = ptr, size
+	return nil
+}
+
+// Free frees the memory block. Using the Memory after freeing it fails
+// with ErrMemoryFreed.
+func (m *Memory) Free() error {
+	if m.ptr == nil {
+		return ErrMemoryFreed
+	}
+
+	if m.allocator != nil {
+		m.allocator.free(m.ptr)
+	}
+	m.ptr = nil
+	return nil
+}
+
+// AsBytes returns a byte slice view of the memory block.
+func (m *Memory) AsBytes() []byte {
+	return memoryView[byte](m)
+}
+
+// AsInt8s returns an int8 slice view of the memory block.
+func (m *Memory) AsInt8s() []int8 {
+	return memoryView[int8](m)
+}
+
+// AsInt16s returns an int16 slice view of the memory block.
+func (m *Memory) AsInt16s() []int16 {
+	return memoryView[int16](m)
+}
+
+// AsInt32s returns an int32 slice view of the memory block.
+func (m *Memory) AsInt32s() []int32 {
+	return memoryView[int32](m)
+}
+
+// AsInt64s returns an int64 slice view of the memory block.
+func (m *Memory) AsInt64s() []int64 {
+	return memoryView[int64](m)
+}
+
+// AsUint16s returns an uint16 slice view of the memory block.
+func (m *Memory) AsUint16s() []uint16 {
+	return memoryView[uint16](m)
+}
+
+// AsUint32s returns an uint32 slice view of the memory block.
+func (m *Memory) AsUint32s() []uint32 {
+	return memoryView[uint32](m)
+}
+
+// AsUint64s returns an uint64 slice view of the memory block.
+func (m *Memory) AsUint64s() []uint64 {
+	return memoryView[uint64](m)
+}
+
+// AsFloat32s returns a float32 slice view of the memory block.
+func (m *Memory) AsFloat32s() []float32 {
+	return memoryView[float32](m)
+}
+
+// AsFloat64s returns a float64 slice view of the memory block.
+func (m *Memory) AsFloat64s() []float64 {
+	return memoryView[float64](m)
+}
+
+// AsSlice returns a slice view of the memory block, with as many elements
+// of type T as fit into the memory block. T must not contain Go pointers.
+func AsSlice[T any](m *Memory) ([]T, error) {
+	if err := checkMemoryType(reflect.TypeOf((*T)(nil)).Elem()); err != nil {
+		return nil, err
+	}
+	return memoryView[T](m), nil
+}
+
+// AsStruct returns a pointer view of the memory block as a value of type T,
+// e.g. a struct matching the memory layout of a C struct. T must not contain
+// Go pointers, and the memory block must be big enough to hold a T.
+func AsStruct[T any](m *Memory) (*T, error) {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if err := checkMemoryType(t); err != nil {
+		return nil, err
+	}
+	if m.ptr == nil {
+		return nil, ErrMemoryFreed
+	}
+	if int(t.Size()) > m.size {
+		return nil, fmt.Errorf("memory block of %d bytes is too small for %s", m.size, t)
+	}
+	return (*T)(m.ptr), nil
+}
+
+func memoryView[T any](m *Memory) []T {
+	var zero T
+	size := int(unsafe.Sizeof(zero))
+	if m.ptr == nil || size == 0 || m.size < size {
+		return nil
+	}
+	return unsafe.Slice((*T)(m.ptr), m.size/size)
+}
+
+func checkMemoryType(t reflect.Type) error {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.String, reflect.Slice, reflect.Map, reflect.Chan,
+		reflect.Func, reflect.Interface, reflect.UnsafePointer:
+		return fmt.Errorf("%s contains Go pointers and can't be stored in native memory", t)
+	case reflect.Array:
+		return checkMemoryType(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if err := checkMemoryType(t.Field(i).Type); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func memoryValue(value reflect.Value) (reflect.Value, error) {
+	m := value.Interface().(*Memory)
+	if m == nil {
+		return reflect.ValueOf(uintptr(0)), nil
+	}
+
+	ptr, err := m.Pointer()
+	if err != nil {
+		return value, err
+	}
+	return reflect.ValueOf(ptr), nil
+}
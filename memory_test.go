@@ -0,0 +1,163 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMemory(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var sum func(*Memory, int32) (int64, error)
+	if err := l.Import("_sum_int32", &sum); err != nil {
+		t.Errorf("Symbol _sum_int32 failed to be imported: %v", err)
+		return
+	}
+
+	m, err := Calloc(4, 4)
+	if err != nil {
+		t.Errorf("allocation failed: %v", err)
+		return
+	}
+
+	values := m.AsInt32s()
+	if len(values) != 4 || values[0] != 0 {
+		t.Errorf("unexpected view: %v", values)
+	}
+	for i := range values {
+		values[i] = int32(i + 1)
+	}
+
+	if s, err := sum(m, 4); err != nil || s != 10 {
+		t.Errorf("expected 10, got %d (%v)", s, err)
+	}
+
+	if err := m.Realloc(32); err != nil {
+		t.Errorf("reallocation failed: %v", err)
+	}
+	values = m.AsInt32s()
+	if len(values) != 8 || values[3] != 4 {
+		t.Errorf("unexpected view after reallocation: %v", values)
+	}
+
+	if err := m.Free(); err != nil {
+		t.Errorf("free failed: %v", err)
+	}
+	if err := m.Free(); err != ErrMemoryFreed {
+		t.Errorf("expected ErrMemoryFreed, got %v", err)
+	}
+	if _, err := sum(m, 4); err != ErrMemoryFreed {
+		t.Errorf("expected ErrMemoryFreed, got %v", err)
+	}
+}
+
+func TestCallocIllegalSize(t *testing.T) {
+	tests := []struct {
+		count, size int
+	}{
+		{-1, 4},
+		{4, -1},
+		{math.MaxInt/8 + 1, 8},
+		{2, math.MaxInt},
+	}
+
+	for _, test := range tests {
+		if _, err := Calloc(test.count, test.size); err != errIllegalSize {
+			t.Errorf("expected errIllegalSize for %d x %d, got %v", test.count, test.size, err)
+		}
+	}
+}
+
+func TestMemoryAsStruct(t *testing.T) {
+	m, err := Alloc(8)
+	if err != nil {
+		t.Errorf("allocation failed: %v", err)
+		return
+	}
+	defer m.Free()
+
+	p, err := AsStruct[point](m)
+	if err != nil {
+		t.Errorf("struct view failed: %v", err)
+		return
+	}
+	p.X, p.Y = 1, 2
+
+	if b := m.AsBytes(); len(b) != 8 || b[0] != 1 || b[4] != 2 {
+		t.Errorf("unexpected bytes: %v", b)
+	}
+
+	if _, err := AsStruct[rect](m); err == nil {
+		t.Error("too small memory blocks should fail")
+	}
+	if _, err := AsSlice[*int](m); err == nil {
+		t.Error("types containing Go pointers should fail")
+	}
+	if points, err := AsSlice[point](m); err != nil || len(points) != 1 || points[0] != *p {
+		t.Errorf("unexpected slice view: %v (%v)", points, err)
+	}
+}
+
+func TestCustomAllocator(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	allocator, err := NewAllocator(l, "_test_malloc", "", "", "_test_free")
+	if err != nil {
+		t.Errorf("Allocator failed to be created: %v", err)
+		return
+	}
+
+	var allocations func() int32
+	if err := l.Import("_test_allocations", &allocations); err != nil {
+		t.Errorf("Symbol _test_allocations failed to be imported: %v", err)
+		return
+	}
+
+	m, err := allocator.Calloc(2, 8)
+	if err != nil {
+		t.Errorf("allocation failed: %v", err)
+		return
+	}
+	if a := allocations(); a != 1 {
+		t.Errorf("expected 1 allocation, got %d", a)
+	}
+
+	m.AsInt64s()[1] = 42
+	if err := m.Realloc(24); err != nil {
+		t.Errorf("reallocation failed: %v", err)
+	}
+	if v := m.AsInt64s(); len(v) != 3 || v[1] != 42 {
+		t.Errorf("unexpected view after reallocation: %v", v)
+	}
+
+	m.Free()
+	if a := allocations(); a != 0 {
+		t.Errorf("expected no allocations, got %d", a)
+	}
+}
@@ -0,0 +1,181 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"expvar"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLatencyBuckets are the upper bounds of the latency histogram
+// buckets used by NewMetrics, if no buckets are given.
+var DefaultLatencyBuckets = []time.Duration{
+	time.Microsecond,
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// Metrics collects call counts, error counts and latency histograms per symbol
+// of the libraries it is set on (see Library.SetMetrics). Metrics are safe for
+// concurrent use, and can be shared by multiple libraries.
+type Metrics struct {
+	buckets []time.Duration
+	symbols sync.Map
+}
+
+// SymbolMetrics is a snapshot of the metrics of a single symbol.
+type SymbolMetrics struct {
+	// Calls is the number of calls.
+	Calls uint64 `json:"calls"`
+
+	// Errors is the number of calls returning an error or raising a panic.
+	Errors uint64 `json:"errors"`
+
+	// TotalDuration is the accumulated duration of all calls.
+	TotalDuration time.Duration `json:"total_duration"`
+
+	// Histogram is the latency histogram of the calls.
+	Histogram []HistogramBucket `json:"histogram"`
+}
+
+// HistogramBucket is a single bucket of a latency histogram.
+type HistogramBucket struct {
+	// UpperBound is the inclusive upper bound of the bucket. The last
+	// bucket of a histogram is unbounded, using the maximum duration.
+	UpperBound time.Duration `json:"upper_bound"`
+
+	// Count is the number of calls in the bucket (not including the
+	// calls of lower buckets).
+	Count uint64 `json:"count"`
+}
+
+type symbolMetrics struct {
+	calls    uint64
+	errors   uint64
+	duration int64
+	counts   []uint64
+}
+
+// NewMetrics creates a new metrics collector, using the given (ascending)
+// upper bounds for the latency histogram buckets. If no buckets are given,
+// DefaultLatencyBuckets are used.
+func NewMetrics(buckets ...time.Duration) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+
+	b := append([]time.Duration(nil), buckets...)
+	sort.Slice(b, func(i, j int) bool {
+		return b[i] < b[j]
+	})
+	return &Metrics{buckets: append(b, time.Duration(math.MaxInt64))}
+}
+
+// Snapshot returns the current metrics of all called symbols.
+func (m *Metrics) Snapshot() map[string]SymbolMetrics {
+	snapshot := make(map[string]SymbolMetrics)
+	m.symbols.Range(func(key, value interface{}) bool {
+		snapshot[key.(string)] = value.(*symbolMetrics).snapshot(m.buckets)
+		return true
+	})
+	return snapshot
+}
+
+// Reset discards all collected metrics.
+func (m *Metrics) Reset() {
+	m.symbols.Range(func(key, value interface{}) bool {
+		m.symbols.Delete(key)
+		return true
+	})
+}
+
+// Publish publishes the metrics snapshot as an expvar variable with the given
+// name. Like expvar.Publish, it panics if the name is already in use.
+func (m *Metrics) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return m.Snapshot()
+	}))
+}
+
+func (m *Metrics) record(symbol string, duration time.Duration, failed bool) {
+	value, ok := m.symbols.Load(symbol)
+	if !ok {
+		value, _ = m.symbols.LoadOrStore(symbol, &symbolMetrics{counts: make([]uint64, len(m.buckets))})
+	}
+
+	s := value.(*symbolMetrics)
+	atomic.AddUint64(&s.calls, 1)
+	if failed {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	atomic.AddInt64(&s.duration, int64(duration))
+
+	i := sort.Search(len(m.buckets), func(i int) bool {
+		return duration <= m.buckets[i]
+	})
+	atomic.AddUint64(&s.counts[i], 1)
+}
+
+func (s *symbolMetrics) snapshot(buckets []time.Duration) SymbolMetrics {
+	histogram := make([]HistogramBucket, len(buckets))
+	for i, bound := range buckets {
+		histogram[i] = HistogramBucket{UpperBound: bound, Count: atomic.LoadUint64(&s.counts[i])}
+	}
+
+	return SymbolMetrics{
+		Calls:         atomic.LoadUint64(&s.calls),
+		Errors:        atomic.LoadUint64(&s.errors),
+		TotalDuration: time.Duration(atomic.LoadInt64(&s.duration)),
+		Histogram:     histogram,
+	}
+}
+
+// SetMetrics sets the metrics collector of the Library, which records all calls
+// to functions imported from the Library, including already imported functions.
+// Passing nil disables the collection of metrics.
+func (l *Library) SetMetrics(metrics *Metrics) {
+	l.metrics.Store(metrics)
+}
+
+func (l *Library) metricsStub(symbol string, sig *signature, stub stubFunc) stubFunc {
+	return func(values []reflect.Value) []reflect.Value {
+		metrics := l.metrics.Load().(*Metrics)
+		if metrics == nil {
+			return stub(values)
+		}
+
+		start := time.Now()
+		failed := true
+		defer func() {
+			metrics.record(symbol, time.Since(start), failed)
+		}()
+
+		results := stub(values)
+		failed = sig.returnsError && !results[len(results)-1].IsNil()
+		return results
+	}
+}
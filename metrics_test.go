@@ -0,0 +1,94 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var sint32 func(int32) int32
+	if err := l.Import("__sint32", &sint32); err != nil {
+		t.Errorf("Symbol __sint32 failed to be imported: %v", err)
+		return
+	}
+
+	var get func(*Handle) (int32, error)
+	if err := l.Import("_handle_get", &get); err != nil {
+		t.Errorf("Symbol _handle_get failed to be imported: %v", err)
+		return
+	}
+
+	metrics := NewMetrics(time.Millisecond, time.Nanosecond)
+	l.SetMetrics(metrics)
+
+	for i := 0; i < 3; i++ {
+		sint32(63)
+	}
+
+	h := NewHandle(0, nil)
+	h.Close()
+	get(h)
+
+	snapshot := metrics.Snapshot()
+	s := snapshot["__sint32"]
+	if s.Calls != 3 || s.Errors != 0 || s.TotalDuration <= 0 {
+		t.Errorf("unexpected metrics for __sint32: %+v", s)
+	}
+
+	if len(s.Histogram) != 3 || s.Histogram[0].UpperBound != time.Nanosecond {
+		t.Errorf("unexpected histogram: %+v", s.Histogram)
+	}
+
+	var count uint64
+	for _, bucket := range s.Histogram {
+		count += bucket.Count
+	}
+	if count != 3 {
+		t.Errorf("expected 3 calls in histogram, got %d", count)
+	}
+
+	if s := snapshot["_handle_get"]; s.Calls != 1 || s.Errors != 1 {
+		t.Errorf("unexpected metrics for _handle_get: %+v", s)
+	}
+
+	metrics.Publish("goffi_test")
+	var published map[string]SymbolMetrics
+	if err := json.Unmarshal([]byte(expvar.Get("goffi_test").String()), &published); err != nil {
+		t.Errorf("failed to decode published metrics: %v", err)
+	}
+	if published["__sint32"].Calls != 3 {
+		t.Errorf("unexpected published metrics: %+v", published)
+	}
+
+	l.SetMetrics(nil)
+	sint32(63)
+	metrics.Reset()
+	if len(metrics.Snapshot()) != 0 {
+		t.Error("expected empty metrics after reset")
+	}
+}
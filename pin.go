@@ -0,0 +1,228 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// PointerRuleError is returned by imported functions, when pointer checks
+// are enabled (see SetPointerChecks) and an argument violates the cgo
+// pointer passing rules.
+type PointerRuleError struct {
+	Symbol    string
+	Parameter int
+	Path      string
+	Reason    string
+}
+
+func (e *PointerRuleError) Error() string {
+	return fmt.Sprintf("parameter %d of %s violates the cgo pointer rules at %s: %s",
+		e.Parameter, e.Symbol, e.Path, e.Reason)
+}
+
+// SetPointerChecks enables or disables the validation of the cgo pointer
+// passing rules for all functions imported from the Library, including
+// already imported functions. Since arguments are passed to C using libffi,
+// the checks of the cgo runtime (see GODEBUG=cgocheck) do not apply to them.
+// The checks walk all Go memory reachable from pointer and slice arguments
+// before the call and are meant to be used for debugging.
+func (l *Library) SetPointerChecks(enabled bool) {
+	l.checks.Store(enabled)
+}
+
+// PointerChecks returns true if the validation of the cgo pointer passing
+// rules is enabled for the Library.
+func (l *Library) PointerChecks() bool {
+	return l.checks.Load().(bool)
+}
+
+// isReference returns true if values of the given type reference Go memory,
+// which is passed to C as a pointer
+func isReference(t reflect.Type) bool {
+	if t == TypeMemory || t == TypeHandle || t == TypeScope {
+		return false
+	}
+	return t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice
+}
+
+func isPointerType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.UnsafePointer, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// hasGoPointers returns true if memory of the given type may contain Go pointers
+func hasGoPointers(t reflect.Type) bool {
+	return checkMemoryType(t) != nil
+}
+
+// newReferences resolves the C types of the memory referenced by pointer and
+// slice parameters, which have a C representation, by parameter index. The
+// bound Self value is stored at index -1.
+func newReferences(goFnType reflect.Type, config *importConfig) map[int]*CType {
+	references := make(map[int]*CType)
+	resolve := func(index int, t reflect.Type) {
+		if !isReference(t) {
+			return
+		}
+		if elem, err := CTypeOf(t.Elem()); err == nil {
+			references[index] = elem
+		}
+	}
+
+	if config.self.IsValid() {
+		resolve(-1, config.self.Type())
+	}
+	for i := 0; i < goFnType.NumIn(); i++ {
+		resolve(i, goFnType.In(i))
+	}
+	return references
+}
+
+// wrapReference passes the Go memory referenced by a pointer or slice value
+// to C. Memory of types with a C representation, resolved as elem at import
+// time, is marshalled (see marshaller). Memory of other types without nested Go pointers is pinned
+// for the lifetime of the arena, otherwise it is copied into the arena and
+// the nested Go pointers are pinned. The returned function copies the memory
+// back after the call.
+func wrapReference(a *arena, value reflect.Value, elem *CType, check, copyBack bool) (unsafe.Pointer, func(), error) {
+	holder := (*unsafe.Pointer)(a.calloc(uintptr(ptrSize)))
+
+	if elem != nil {
+		m := newMarshaller(a)
+		ptr, err := m.reference(value, elem)
+		if err != nil {
+			return nil, nil, err
+		}
+		*holder = ptr
+		return unsafe.Pointer(holder), m.copyBackFunc(copyBack), nil
+	}
+
+	t := value.Type()
+	n := 1
+	if t.Kind() == reflect.Slice {
+		if value.Cap() == 0 {
+			return unsafe.Pointer(holder), nil, nil
+		}
+		n = value.Len()
+	} else if value.IsNil() {
+		return unsafe.Pointer(holder), nil, nil
+	}
+
+	data := value.UnsafePointer()
+	if !hasGoPointers(t.Elem()) {
+		a.pin(data)
+		*holder = data
+		return unsafe.Pointer(holder), nil, nil
+	}
+
+	at := reflect.ArrayOf(n, t.Elem())
+	original := reflect.NewAt(at, data).Elem()
+	copied := reflect.NewAt(at, a.alloc(at.Size())).Elem()
+	copied.Set(original)
+
+	root := copied
+	if t.Kind() == reflect.Ptr {
+		root = copied.Index(0)
+	}
+	if err := pinNested(a, root, "arg", check, make(map[unsafe.Pointer]bool)); err != nil {
+		return nil, nil, err
+	}
+
+	*holder = unsafe.Pointer(copied.UnsafeAddr())
+	if !copyBack {
+		return unsafe.Pointer(holder), nil, nil
+	}
+	return unsafe.Pointer(holder), func() {
+		original.Set(copied)
+	}, nil
+}
+
+// pinNested pins all Go memory reachable from the given value. If check is
+// set, values which cannot be pinned fail with a PointerRuleError.
+func pinNested(a *arena, value reflect.Value, path string, check bool, seen map[unsafe.Pointer]bool) error {
+	t := value.Type()
+	switch t.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return nil
+		}
+		ptr := value.UnsafePointer()
+		a.pin(ptr)
+		if seen[ptr] || !hasGoPointers(t.Elem()) {
+			return nil
+		}
+		seen[ptr] = true
+		return pinNested(a, value.Elem(), path, check, seen)
+
+	case reflect.UnsafePointer:
+		a.pin(value.UnsafePointer())
+
+	case reflect.String:
+		if value.Len() > 0 {
+			a.pin(unsafe.Pointer(unsafe.StringData(value.String())))
+		}
+
+	case reflect.Slice:
+		if value.Cap() > 0 {
+			a.pin(value.UnsafePointer())
+		}
+		if !hasGoPointers(t.Elem()) {
+			return nil
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := pinNested(a, value.Index(i), fmt.Sprintf("%s[%d]", path, i), check, seen); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Array:
+		if !hasGoPointers(t.Elem()) {
+			return nil
+		}
+		for i := 0; i < value.Len(); i++ {
+			if err := pinNested(a, value.Index(i), fmt.Sprintf("%s[%d]", path, i), check, seen); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !hasGoPointers(t.Field(i).Type) {
+				continue
+			}
+			if err := pinNested(a, value.Field(i), path+"."+t.Field(i).Name, check, seen); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map, reflect.Chan, reflect.Func, reflect.Interface:
+		if check && !value.IsNil() {
+			return &PointerRuleError{
+				Path:   path,
+				Reason: fmt.Sprintf("Go pointer of type %s cannot be pinned", t),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"testing"
+)
+
+type labeled struct {
+	Label  *byte
+	Length int32
+}
+
+type unpinnable struct {
+	Values map[string]int
+	Length int32
+}
+
+func TestPinnedPointer(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var increment func(*int32) error
+	if err := l.Import("_increment_int32", &increment); err != nil {
+		t.Errorf("Symbol _increment_int32 failed to be imported: %v", err)
+		return
+	}
+
+	value := int32(41)
+	if err := increment(&value); err != nil {
+		t.Errorf("call failed: %v", err)
+	}
+	if value != 42 {
+		t.Errorf("expected 42, got %d", value)
+	}
+
+	var isNull func(*int32) int32
+	if err := l.Import("_is_null", &isNull); err != nil {
+		t.Errorf("Symbol _is_null failed to be imported: %v", err)
+		return
+	}
+	if isNull(nil) != 1 || isNull(&value) != 0 {
+		t.Errorf("nil pointers are expected to be passed as NULL")
+	}
+}
+
+func TestPinnedSlice(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var sum func([]int32, int32) int64
+	if err := l.Import("_sum_int32", &sum); err != nil {
+		t.Errorf("Symbol _sum_int32 failed to be imported: %v", err)
+		return
+	}
+
+	values := []int32{1, 2, 3, 4, 5}
+	if s := sum(values, int32(len(values))); s != 15 {
+		t.Errorf("expected 15, got %d", s)
+	}
+	if s := sum(nil, 0); s != 0 {
+		t.Errorf("expected 0, got %d", s)
+	}
+}
+
+func TestCopiedPointer(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+	l.SetPointerChecks(true)
+
+	var measure func(*labeled) (int32, error)
+	if err := l.Import("_measure_label", &measure); err != nil {
+		t.Errorf("Symbol _measure_label failed to be imported: %v", err)
+		return
+	}
+
+	label := []byte("goffi\x00")
+	arg := &labeled{Label: &label[0]}
+	if n, err := measure(arg); err != nil || n != 5 {
+		t.Errorf("expected 5, got %d (%v)", n, err)
+	}
+	if arg.Length != 5 {
+		t.Errorf("expected the field to be copied back, got %d", arg.Length)
+	}
+	if arg.Label != &label[0] {
+		t.Errorf("expected the nested pointer to be unchanged")
+	}
+}
+
+func TestPointerChecks(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var isNull func(*unpinnable) (int32, error)
+	if err := l.Import("_is_null", &isNull); err != nil {
+		t.Errorf("Symbol _is_null failed to be imported: %v", err)
+		return
+	}
+
+	arg := &unpinnable{Values: map[string]int{"a": 1}}
+	if _, err := isNull(arg); err != nil {
+		t.Errorf("expected no error without pointer checks, got %v", err)
+	}
+
+	l.SetPointerChecks(true)
+	if !l.PointerChecks() {
+		t.Errorf("expected pointer checks to be enabled")
+	}
+
+	_, err = isNull(arg)
+	e, ok := err.(*PointerRuleError)
+	if !ok {
+		t.Errorf("expected PointerRuleError, got %v", err)
+		return
+	}
+	if e.Symbol != "_is_null" || e.Parameter != 0 || e.Path != "arg.Values" {
+		t.Errorf("unexpected error: %v", e)
+	}
+}
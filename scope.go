@@ -0,0 +1,231 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+/*
+#include <stdlib.h>
+#include <string.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// TypeScope represents a *Scope. A function taking a *Scope as its first
+// parameter (after an optional context.Context) allocates the temporary C
+// memory of its arguments in the given scope, the scope itself is not passed
+// to the C function.
+var TypeScope = reflect.TypeOf((*Scope)(nil))
+
+// ErrScopeClosed is returned when memory is allocated in a closed scope
+var ErrScopeClosed = errors.New("scope is closed")
+
+const (
+	arenaBlockSize = 4096
+	arenaAlignment = 16
+)
+
+// arena is a bump allocator collecting C allocations into larger blocks,
+// which are freed in one step
+type arena struct {
+	blocks []unsafe.Pointer
+	offset uintptr
+	size   uintptr
+	pinner runtime.Pinner
+	pinned bool
+}
+
+func (a *arena) alloc(size uintptr) unsafe.Pointer {
+	size = alignUp(size, arenaAlignment)
+	if size == 0 {
+		size = arenaAlignment
+	}
+
+	if len(a.blocks) == 0 || a.offset+size > a.size {
+		blockSize := uintptr(arenaBlockSize)
+		if size > blockSize {
+			blockSize = size
+		}
+
+		block := C.malloc(C.size_t(blockSize))
+		if block == nil {
+			panic(ErrOutOfMemory)
+		}
+		a.blocks = append(a.blocks, block)
+		a.offset = 0
+		a.size = blockSize
+	}
+
+	ptr := unsafe.Add(a.blocks[len(a.blocks)-1], a.offset)
+	a.offset += size
+	return ptr
+}
+
+func (a *arena) calloc(size uintptr) unsafe.Pointer {
+	ptr := a.alloc(size)
+	C.memset(ptr, 0, C.size_t(size))
+	return ptr
+}
+
+func (a *arena) cstring(s string) unsafe.Pointer {
+	ptr := a.alloc(uintptr(len(s) + 1))
+	buf := unsafe.Slice((*byte)(ptr), len(s)+1)
+	copy(buf, s)
+	buf[len(s)] = 0
+	return ptr
+}
+
+// pin pins the Go object at the given address until the arena is freed.
+// Pointers to C memory or static data are ignored by the runtime.Pinner.
+func (a *arena) pin(ptr unsafe.Pointer) {
+	if ptr != nil {
+		a.pinner.Pin(ptr)
+		a.pinned = true
+	}
+}
+
+// arenaFreeHook is called before an arena is freed, used by tests only
+var arenaFreeHook func(a *arena)
+
+func (a *arena) free() {
+	if arenaFreeHook != nil {
+		arenaFreeHook(a)
+	}
+	if a.pinned {
+		a.pinner.Unpin()
+		a.pinned = false
+	}
+	for _, block := range a.blocks {
+		C.free(block)
+	}
+	a.blocks = nil
+	a.offset = 0
+	a.size = 0
+}
+
+// Scope collects the temporary C allocations of one or more calls and
+// frees them in one step, when the scope is closed. Go memory pinned
+// for those calls stays pinned until the scope is closed as well. Passing a scope
+// explicitly to an imported function (see TypeScope) keeps the converted
+// arguments alive until the scope ends, which means pointers returned by
+// the C function into those arguments stay valid as well.
+// A Scope must always be closed when it's not needed anymore. A scope, which
+// becomes unreachable without being closed, is closed when collected by
+// the Go runtime, which may happen much later than expected and keeps
+// all its C memory and pinned Go memory until then.
+// A Scope is safe for concurrent use by multiple goroutines.
+type Scope struct {
+	m      sync.Mutex
+	arena  arena
+	closed bool
+}
+
+// NewScope creates a new, empty scope
+func NewScope() *Scope {
+	s := &Scope{}
+	runtime.SetFinalizer(s, (*Scope).Close)
+	return s
+}
+
+// Alloc allocates a memory block of the given size in the scope. The
+// memory block is freed when the scope is closed, calling Free on the
+// returned memory has no effect on the allocation.
+func (s *Scope) Alloc(size int) (*Memory, error) {
+	if size < 0 {
+		return nil, errIllegalSize
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return nil, ErrScopeClosed
+	}
+	return &Memory{ptr: s.arena.calloc(uintptr(size)), size: size}, nil
+}
+
+// CString copies the given string into a NUL terminated C string allocated
+// in the scope and returns its address.
+func (s *Scope) CString(str string) (uintptr, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return 0, ErrScopeClosed
+	}
+	return uintptr(s.arena.cstring(str)), nil
+}
+
+// Close frees all memory allocated in the scope. Pointers into the scope
+// must not be used after the scope is closed. Calling Close on a closed
+// scope has no effect.
+func (s *Scope) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if !s.closed {
+		s.arena.free()
+		s.closed = true
+		runtime.SetFinalizer(s, nil)
+	}
+	return nil
+}
+
+// allocate runs the given function with the arena of the scope locked
+func (s *Scope) allocate(fn func(a *arena)) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return ErrScopeClosed
+	}
+	fn(&s.arena)
+	return nil
+}
+
+// scopeFnType returns the function type without a leading scope parameter,
+// and if the function type had one.
+func scopeFnType(fnType reflect.Type) (reflect.Type, bool) {
+	if fnType.NumIn() == 0 || fnType.In(0) != TypeScope {
+		return fnType, false
+	}
+
+	in := make([]reflect.Type, fnType.NumIn()-1)
+	for i := range in {
+		in[i] = fnType.In(i + 1)
+	}
+
+	out := make([]reflect.Type, fnType.NumOut())
+	for i := range out {
+		out[i] = fnType.Out(i)
+	}
+	return reflect.FuncOf(in, out, fnType.IsVariadic()), true
+}
+
+// goSideFnType returns the function type without the parameters, which only
+// exist on the Go side, namely a leading context and scope.
+func goSideFnType(fnType reflect.Type) reflect.Type {
+	fnType, _ = contextFnType(fnType)
+	fnType, _ = scopeFnType(fnType)
+	return fnType
+}
@@ -0,0 +1,183 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"context"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"unsafe"
+)
+
+func TestScope(t *testing.T) {
+	l, err := NewLibrary("libc", BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var strchr func(*Scope, string, int32) (uintptr, error)
+	if err := l.Import("strchr", &strchr); err != nil {
+		t.Errorf("Symbol strchr failed to be imported: %v", err)
+		return
+	}
+
+	scope := NewScope()
+	first, err := strchr(scope, "hello world", ' ')
+	if err != nil || first == 0 {
+		t.Errorf("strchr failed: %v", err)
+		return
+	}
+	second, err := strchr(scope, "goodbye moon", ' ')
+	if err != nil || second == 0 {
+		t.Errorf("strchr failed: %v", err)
+		return
+	}
+
+	// both arguments are still alive, since the scope is not closed yet
+	if s := string(NewMemory(first+1, 5, nil).AsBytes()); s != "world" {
+		t.Errorf("expected world, got %s", s)
+	}
+	if s := string(NewMemory(second+1, 4, nil).AsBytes()); s != "moon" {
+		t.Errorf("expected moon, got %s", s)
+	}
+
+	if err := scope.Close(); err != nil {
+		t.Errorf("scope failed to close: %v", err)
+	}
+	if _, err := strchr(scope, "hello world", ' '); err != ErrScopeClosed {
+		t.Errorf("expected ErrScopeClosed, got %v", err)
+	}
+
+	// without a scope the arguments only live for the call
+	if p, err := strchr(nil, "hello world", 'w'); err != nil || p == 0 {
+		t.Errorf("strchr without scope failed: %v", err)
+	}
+}
+
+func TestScopeWithContext(t *testing.T) {
+	l, err := NewLibrary("libc", BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var strlen func(context.Context, *Scope, string) (uint64, error)
+	if err := l.Import("strlen", &strlen); err != nil {
+		t.Errorf("Symbol strlen failed to be imported: %v", err)
+		return
+	}
+
+	scope := NewScope()
+	defer scope.Close()
+
+	if n, err := strlen(context.Background(), scope, "hello"); err != nil || n != 5 {
+		t.Errorf("expected 5, got %d (%v)", n, err)
+	}
+}
+
+func TestScopeAlloc(t *testing.T) {
+	scope := NewScope()
+
+	m, err := scope.Alloc(8192)
+	if err != nil {
+		t.Errorf("allocation failed: %v", err)
+		return
+	}
+	values := m.AsInt64s()
+	if len(values) != 1024 || values[1023] != 0 {
+		t.Errorf("unexpected view: %d values", len(values))
+	}
+
+	if _, err := scope.Alloc(-1); err != errIllegalSize {
+		t.Errorf("expected errIllegalSize, got %v", err)
+	}
+
+	p, err := scope.CString("scoped")
+	if err != nil || p == 0 {
+		t.Errorf("string allocation failed: %v", err)
+	}
+	if s := string(NewMemory(p, 7, nil).AsBytes()); s != "scoped\x00" {
+		t.Errorf("expected scoped, got %q", s)
+	}
+
+	scope.Close()
+	if _, err := scope.Alloc(8); err != ErrScopeClosed {
+		t.Errorf("expected ErrScopeClosed, got %v", err)
+	}
+	if _, err := scope.CString("closed"); err != ErrScopeClosed {
+		t.Errorf("expected ErrScopeClosed, got %v", err)
+	}
+}
+
+func TestScopeWithoutClose(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var increment func(*Scope, *int32) error
+	if err := l.Import("_increment_int32", &increment); err != nil {
+		t.Errorf("Symbol _increment_int32 failed to be imported: %v", err)
+		return
+	}
+
+	// the dropped scope is only identified by the address of its arena,
+	// which must not keep it reachable
+	var freed, unpinned atomic.Bool
+	var scopeArena atomic.Uintptr
+	arenaFreeHook = func(a *arena) {
+		if uintptr(unsafe.Pointer(a)) == scopeArena.Load() {
+			unpinned.Store(a.pinned)
+			freed.Store(true)
+		}
+	}
+	defer func() {
+		arenaFreeHook = nil
+	}()
+
+	func() {
+		scope := NewScope()
+		scopeArena.Store(uintptr(unsafe.Pointer(&scope.arena)))
+
+		value := int32(41)
+		if err := increment(scope, &value); err != nil {
+			t.Errorf("call failed: %v", err)
+		}
+		if value != 42 {
+			t.Errorf("expected 42, got %d", value)
+		}
+	}()
+
+	// the pinned value must be released when the dropped scope is collected
+	if !eventually(func() bool {
+		runtime.GC()
+		return freed.Load()
+	}) {
+		t.Error("dropped scope wasn't freed")
+		return
+	}
+	if !unpinned.Load() {
+		t.Error("pinned memory of the dropped scope wasn't unpinned")
+	}
+}
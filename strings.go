@@ -0,0 +1,156 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+/*
+#include <stdlib.h>
+#include <stdint.h>
+
+static char **_stringArray(uintptr_t ptr) {
+	return (char **)ptr;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"reflect"
+	"unsafe"
+)
+
+// TypeStrings represents a Go []string. As a parameter, this type is
+// translated into a NULL terminated char** array in C. As a result, a
+// char** array is read into a []string (see StringArrayLength and
+// FreeStringArray).
+var TypeStrings = reflect.TypeOf([]string(nil))
+
+var (
+	errStringArrayResult = errors.New("string array options require a []string result")
+	errStringArrayLength = errors.New("string array length must be an integer parameter")
+)
+
+// stringArray configures how a returned char** array is read
+type stringArray struct {
+	length       int
+	freeArray    bool
+	freeElements bool
+}
+
+func (config *importConfig) stringArrayConfig() *stringArray {
+	if config.stringArray == nil {
+		config.stringArray = &stringArray{length: -1}
+	}
+	return config.stringArray
+}
+
+// StringArrayLength defines that the char** array returned by the imported
+// function has as many elements, as the value of the given parameter. The
+// index doesn't count a leading context or scope parameter. By default, the
+// returned array is expected to be NULL terminated.
+func StringArrayLength(param int) ImportOption {
+	return func(config *importConfig) {
+		config.stringArrayConfig().length = param
+	}
+}
+
+// FreeStringArray defines if the outer array and the elements of the char**
+// array returned by the imported function are freed, after they are copied
+// into the []string. By default, nothing is freed.
+// The elements of arrays allocated as a single block (e.g. by
+// backtrace_symbols) must not be freed separately.
+func FreeStringArray(array, elements bool) ImportOption {
+	return func(config *importConfig) {
+		c := config.stringArrayConfig()
+		c.freeArray, c.freeElements = array, elements
+	}
+}
+
+// newStringArray validates the string array configuration of the given
+// function type
+func newStringArray(goFnType reflect.Type, returnsValue bool, config *importConfig) (*stringArray, error) {
+	if !returnsValue || goFnType.Out(0) != TypeStrings {
+		if config.stringArray != nil {
+			return nil, errStringArrayResult
+		}
+		return nil, nil
+	}
+
+	c := config.stringArray
+	if c == nil {
+		return &stringArray{length: -1}, nil
+	}
+
+	if c.length >= 0 {
+		if c.length >= goFnType.NumIn() {
+			return nil, errStringArrayLength
+		}
+		switch goFnType.In(c.length).Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			return nil, errStringArrayLength
+		}
+	}
+	return c, nil
+}
+
+// read copies the returned char** array into a []string
+func (s *stringArray) read(value reflect.Value, values []reflect.Value) reflect.Value {
+	array := C._stringArray(C.uintptr_t(value.Uint()))
+	if array == nil {
+		return reflect.Zero(TypeStrings)
+	}
+
+	length := -1
+	if s.length >= 0 {
+		length = int(integerValue(values[s.length]))
+	}
+
+	var result []string
+	for i := 0; length < 0 || i < length; i++ {
+		element := *(**C.char)(unsafe.Add(unsafe.Pointer(array), uintptr(i)*uintptr(ptrSize)))
+		if length < 0 && element == nil {
+			break
+		}
+
+		if element == nil {
+			result = append(result, "")
+			continue
+		}
+		result = append(result, C.GoString(element))
+		if s.freeElements {
+			C.free(unsafe.Pointer(element))
+		}
+	}
+
+	if s.freeArray {
+		C.free(unsafe.Pointer(array))
+	}
+	if result == nil {
+		result = []string{}
+	}
+	return reflect.ValueOf(result)
+}
+
+func integerValue(value reflect.Value) int64 {
+	switch value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint())
+	}
+	return value.Int()
+}
@@ -0,0 +1,132 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStringArrayParameter(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var count func([]string) int32
+	if err := l.Import("_count_strings", &count); err != nil {
+		t.Errorf("Symbol _count_strings failed to be imported: %v", err)
+		return
+	}
+
+	if n := count([]string{"prog", "-v", "file"}); n != 3 {
+		t.Errorf("expected 3, got %d", n)
+	}
+	if n := count([]string{}); n != 0 {
+		t.Errorf("expected empty arrays to be NULL terminated, got %d", n)
+	}
+	if n := count(nil); n != -1 {
+		t.Errorf("expected nil to be passed as NULL, got %d", n)
+	}
+}
+
+type wordLists struct {
+	First  []string
+	Second []string
+}
+
+func TestStringArraySharedBacking(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var count func(*wordLists) int32
+	if err := l.Import("_count_word_lists", &count); err != nil {
+		t.Errorf("Symbol _count_word_lists failed to be imported: %v", err)
+		return
+	}
+
+	// both slices share the same backing array, but differ in length
+	words := []string{"a", "b", "c"}
+	if n := count(&wordLists{First: words[:1], Second: words}); n != 103 {
+		t.Errorf("expected 103, got %d", n)
+	}
+	if n := count(&wordLists{First: words, Second: words}); n != 303 {
+		t.Errorf("expected 303, got %d", n)
+	}
+}
+
+func TestStringArrayResult(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var static func() []string
+	if err := l.Import("_static_words", &static); err != nil {
+		t.Errorf("Symbol _static_words failed to be imported: %v", err)
+		return
+	}
+	if words := static(); !reflect.DeepEqual(words, []string{"alpha", "beta"}) {
+		t.Errorf("unexpected words: %v", words)
+	}
+
+	var split func(string) ([]string, error)
+	if err := l.Import("_split_words", &split, FreeStringArray(true, true)); err != nil {
+		t.Errorf("Symbol _split_words failed to be imported: %v", err)
+		return
+	}
+	if words, err := split("call me maybe"); err != nil || !reflect.DeepEqual(words, []string{"call", "me", "maybe"}) {
+		t.Errorf("unexpected words: %v (%v)", words, err)
+	}
+
+	var block func(int32) []string
+	if err := l.Import("_block_words", &block, StringArrayLength(0), FreeStringArray(true, false)); err != nil {
+		t.Errorf("Symbol _block_words failed to be imported: %v", err)
+		return
+	}
+	if words := block(3); !reflect.DeepEqual(words, []string{"word0", "word1", "word2"}) {
+		t.Errorf("unexpected words: %v", words)
+	}
+}
+
+func TestStringArrayIllegalOptions(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var count func([]string) int32
+	if err := l.Import("_count_strings", &count, StringArrayLength(0)); err != errStringArrayResult {
+		t.Errorf("expected errStringArrayResult, got %v", err)
+	}
+
+	var block func(string) []string
+	if err := l.Import("_block_words", &block, StringArrayLength(0)); err != errStringArrayLength {
+		t.Errorf("expected errStringArrayLength, got %v", err)
+	}
+}
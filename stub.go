@@ -56,7 +56,9 @@ static void _ffi_call(ffi_cif *cif, void(*fn)(void), void *rvalue, void **values
 */
 import "C"
 import (
+	"context"
 	"reflect"
+	"runtime"
 	"unsafe"
 )
 
@@ -66,24 +68,113 @@ var (
 	intSize  = int(C._intSize)
 )
 
-func makeStub(inFnType, outFnType reflect.Type, cif *C.ffi_cif, funcPtr functionPointer, outType ffiType,
-	inTypes []ffiType, returnsError bool) func(values []reflect.Value) []reflect.Value {
+type stubFunc = func(values []reflect.Value) []reflect.Value
+
+// callFunc is a stage of an imported function call, which is passed the
+// context of the call
+type callFunc = func(ctx context.Context, values []reflect.Value) []reflect.Value
+
+func makeStub(sig *signature, cif *C.ffi_cif, funcPtr functionPointer, outType ffiType) stubFunc {
+	inFnType, outFnType := sig.goFnType, sig.cFnType
+	returnsError := sig.returnsError
 
 	return func(values []reflect.Value) []reflect.Value {
-		nargs := len(values)
+		// temporary allocations live in the passed scope, or for the call only
+		var scope *Scope
+		if sig.scoped {
+			scope, values = values[0].Interface().(*Scope), values[1:]
+		}
+
+		var temp arena
+		defer temp.free()
+
+		nargs := outFnType.NumIn()
 
 		args := C.argsArrayNew(C.int(nargs))
-		finalizers := make([]finalizer, 0)
-		for i := 0; i < nargs; i++ {
-			if inFnType.In(i) != outFnType.In(i) {
-				values[i] = convertValue(values[i], outFnType.In(i))
+		outParams := make([]unsafe.Pointer, len(sig.outParams))
+		var copyBacks []func()
+
+		// handles passed to the call must not be destroyed before it returned
+		var releases []func()
+		defer func() {
+			for _, release := range releases {
+				release()
 			}
-			arg, fin := wrapValue(values[i])
+		}()
+
+		prepare := func(a *arena) error {
+			for i, j := 0, 0; i < nargs; i++ {
+				if o := sig.outParam(i); o >= 0 {
+					arg, ptr := allocOutParam(a, outFnType.In(i).Elem())
+					outParams[o] = ptr
+					C.argsArraySet(args, C.int(i), arg)
+					continue
+				}
 
-			if fin != nil {
-				finalizers = append(finalizers, fin)
+				value, param := sig.self, -1
+				if i > 0 || !value.IsValid() {
+					value, param = values[j], j
+					j++
+				}
+
+				if param >= 0 && sig.stringParams != nil && sig.stringParams[param] != StringUTF8 {
+					C.argsArraySet(args, C.int(i), wrapString(a, value, sig.stringParams[param]))
+					continue
+				}
+
+				if isReference(value.Type()) && isPointerType(outFnType.In(i)) {
+					arg, copyBack, err := wrapReference(a, value, sig.references[param], sig.pointerChecks(), sig.copyBack)
+					if err != nil {
+						if e, ok := err.(*PointerRuleError); ok {
+							e.Symbol, e.Parameter = sig.symbol, param
+						}
+						return err
+					}
+					if copyBack != nil {
+						copyBacks = append(copyBacks, copyBack)
+					}
+					C.argsArraySet(args, C.int(i), arg)
+					continue
+				}
+
+				if needsMarshalling(value.Type()) {
+					arg, m, err := wrapAggregate(a, value, outFnType.In(i))
+					if err != nil {
+						return err
+					}
+					if copyBack := m.copyBackFunc(sig.copyBack); copyBack != nil {
+						copyBacks = append(copyBacks, copyBack)
+					}
+					C.argsArraySet(args, C.int(i), arg)
+					continue
+				}
+
+				value, release, err := prepareArgument(value, outFnType.In(i))
+				if err != nil {
+					return err
+				}
+				if release != nil {
+					releases = append(releases, release)
+				}
+				C.argsArraySet(args, C.int(i), wrapValue(a, value))
 			}
-			C.argsArraySet(args, C.int(i), arg)
+			return nil
+		}
+
+		var err error
+		if scope != nil {
+			if serr := scope.allocate(func(a *arena) { err = prepare(a) }); serr != nil {
+				err = serr
+			}
+		} else {
+			err = prepare(&temp)
+		}
+		if err != nil {
+			C.argsArrayFree(args)
+			if returnsError {
+				return errorResults(inFnType, err)
+			}
+			panic(err)
 		}
 
 		var cargs C.argumentsPtr
@@ -91,26 +182,60 @@ func makeStub(inFnType, outFnType reflect.Type, cif *C.ffi_cif, funcPtr function
 			cargs = args
 		}
 
-		ot := unwrapType(outType)
+		// void results are ignored by libffi, still rvalue needs to be valid
+		ot := TypeUint64
+		if outType != typeVoid {
+			ot = unwrapType(outType)
+		}
 		out := reflect.New(ot)
-		_, err := C._ffi_call(cif, funcPtr, unsafe.Pointer(out.Elem().UnsafeAddr()), cargs)
+		rvalue := unsafe.Pointer(out.Elem().UnsafeAddr())
+
+		if sig.guarded {
+			err = guardedCall(sig, cif, funcPtr, rvalue, (*unsafe.Pointer)(cargs))
+		} else {
+			_, err = C._ffi_call(cif, funcPtr, rvalue, cargs)
+		}
+		C.argsArrayFree(args)
+		runtime.KeepAlive(values)
+		for _, copyBack := range copyBacks {
+			copyBack()
+		}
 		if err != nil {
 			if returnsError {
-				return []reflect.Value{valueNil, reflect.ValueOf(err)}
+				return errorResults(inFnType, err)
 			}
 			panic(err)
 		}
-		C.argsArrayFree(args)
-
-		for i := 0; i < len(finalizers); i++ {
-			finalizers[i]()
-		}
 
-		retValues := make([]reflect.Value, 0)
-		if inFnType.NumOut() > 0 {
+		retValues := make([]reflect.Value, 0, inFnType.NumOut())
+		firstOut := 0
+		if sig.returnsValue {
 			rt := inFnType.Out(0)
-			out = convertValue(out, rt)
+			if sig.stringArray != nil {
+				out = sig.stringArray.read(out.Elem(), values)
+			} else if sig.stringResult != StringUTF8 {
+				out = readString(out.Elem(), sig.stringResult, rt)
+			} else {
+				out = sig.convertResult(out, rt)
+			}
 			retValues = append(retValues, out)
+			firstOut = 1
+		}
+
+		for i, ptr := range outParams {
+			ct := outFnType.In(sig.outParams[i]).Elem()
+			value := reflect.New(ct).Elem()
+			value.Set(reflect.NewAt(ct, ptr).Elem())
+			retValues = append(retValues, sig.convertResult(value, inFnType.Out(firstOut+i)))
+		}
+
+		for _, value := range retValues {
+			if err := checkEnumValue(value); err != nil {
+				if returnsError {
+					return errorResults(inFnType, err)
+				}
+				panic(err)
+			}
 		}
 
 		if returnsError {
@@ -120,3 +245,61 @@ func makeStub(inFnType, outFnType reflect.Type, cif *C.ffi_cif, funcPtr function
 		return retValues
 	}
 }
+
+func prepareArgument(value reflect.Value, t reflect.Type) (reflect.Value, func(), error) {
+	var release func()
+	switch value.Type() {
+	case TypeHandle:
+		v, r, err := handleValue(value)
+		if err != nil {
+			return value, nil, err
+		}
+		value, release = v, r
+	case TypeMemory:
+		v, err := memoryValue(value)
+		if err != nil {
+			return value, nil, err
+		}
+		value = v
+	}
+
+	if value.Type() != t {
+		value = convertValue(value, t)
+	}
+	value, err := enumArgument(value)
+	if err != nil && release != nil {
+		release()
+		release = nil
+	}
+	return value, release, err
+}
+
+func (s *signature) convertResult(value reflect.Value, t reflect.Type) reflect.Value {
+	if t == TypeHandle {
+		return makeHandle(value, s.destructor)
+	}
+	return convertValue(value, t)
+}
+
+func allocOutParam(a *arena, t reflect.Type) (unsafe.Pointer, unsafe.Pointer) {
+	ptr := a.calloc(t.Size())
+	holder := (*unsafe.Pointer)(a.alloc(uintptr(ptrSize)))
+	*holder = ptr
+	return unsafe.Pointer(holder), ptr
+}
+
+func (s *signature) fail(err error) []reflect.Value {
+	if s.returnsError {
+		return errorResults(s.goFnType, err)
+	}
+	panic(err)
+}
+
+func errorResults(fnType reflect.Type, err error) []reflect.Value {
+	retValues := make([]reflect.Value, fnType.NumOut())
+	for i := 0; i < fnType.NumOut()-1; i++ {
+		retValues[i] = reflect.Zero(fnType.Out(i))
+	}
+	retValues[fnType.NumOut()-1] = reflect.ValueOf(err)
+	return retValues
+}
@@ -21,6 +21,11 @@
 #include <string.h>
 #include <math.h>
 #include <stdbool.h>
+#include <pthread.h>
+#include <unistd.h>
+#include <time.h>
+#include <errno.h>
+#include <wchar.h>
 
 extern void empty(void) {
     // do nothing
@@ -139,3 +144,312 @@ extern const char *_char(const char *v, int length) {
     memcpy(r, v, length);
     return r;
 }
+
+extern int32_t _div(int32_t a, int32_t b, int32_t *rem) {
+    *rem = a % b;
+    return a / b;
+}
+
+extern int32_t _rdiv(int32_t *rem, int32_t a, int32_t b) {
+    *rem = a % b;
+    return a / b;
+}
+
+extern void _divmod(int32_t a, int32_t *quot, int32_t b, int32_t *rem) {
+    *quot = a / b;
+    *rem = a % b;
+}
+
+static int32_t handles_freed = 0;
+
+extern int32_t *_handle_new(int32_t v) {
+    int32_t *h = (int32_t *)malloc(sizeof(int32_t));
+    *h = v;
+    return h;
+}
+
+extern int32_t _handle_get(int32_t *h) {
+    return *h;
+}
+
+extern int32_t _handle_get_scaled(int32_t *h, int32_t factor, int32_t *scaled) {
+    *scaled = *h * factor;
+    return *h;
+}
+
+extern void _handle_free(int32_t *h) {
+    handles_freed++;
+    free(h);
+}
+
+extern int32_t _handles_freed() {
+    return handles_freed;
+}
+
+extern int8_t _enum_next(int8_t v) {
+    return v + 1;
+}
+
+extern int32_t get_answer() {
+    return 42;
+}
+
+struct point {
+    int32_t x;
+    int32_t y;
+};
+
+struct rect {
+    struct point min;
+    struct point max;
+    uint8_t flags[3];
+};
+
+union value {
+    int64_t i;
+    double d;
+};
+
+extern struct point _point_add(struct point a, struct point b) {
+    struct point r = { a.x + b.x, a.y + b.y };
+    return r;
+}
+
+extern int32_t _rect_area(struct rect r) {
+    return (r.max.x - r.min.x) * (r.max.y - r.min.y) + r.flags[0] + r.flags[1] + r.flags[2];
+}
+
+extern int64_t _value_int(union value v) {
+    return v.i;
+}
+
+extern uint64_t _thread_id() {
+    return (uint64_t)pthread_self();
+}
+
+static int32_t running = 0;
+static int32_t max_running = 0;
+
+extern int32_t _concurrent_call(int32_t sleep_ms) {
+    int32_t current = __atomic_add_fetch(&running, 1, __ATOMIC_SEQ_CST);
+    int32_t max = __atomic_load_n(&max_running, __ATOMIC_SEQ_CST);
+    while (current > max && !__atomic_compare_exchange_n(&max_running, &max, current, false, __ATOMIC_SEQ_CST, __ATOMIC_SEQ_CST)) {
+    }
+    usleep(sleep_ms * 1000);
+    __atomic_sub_fetch(&running, 1, __ATOMIC_SEQ_CST);
+    return current;
+}
+
+extern int32_t _concurrent_max_reset() {
+    return __atomic_exchange_n(&max_running, 0, __ATOMIC_SEQ_CST);
+}
+
+static volatile int32_t blocking_cancelled = 0;
+static volatile int32_t blocking_returned = 0;
+
+extern int32_t _blocking_wait(int32_t timeout_ms) {
+    blocking_cancelled = 0;
+    blocking_returned = 0;
+    for (int32_t i = 0; i < timeout_ms && !blocking_cancelled; i++) {
+        usleep(1000);
+    }
+    blocking_returned = 1;
+    return blocking_cancelled;
+}
+
+extern void _blocking_cancel() {
+    blocking_cancelled = 1;
+}
+
+extern int32_t _blocking_returned() {
+    return blocking_returned;
+}
+
+static volatile int32_t sleep_interrupted = 0;
+
+extern int32_t _interruptible_sleep(int32_t timeout_ms) {
+    struct timespec ts = { timeout_ms / 1000, (timeout_ms % 1000) * 1000000L };
+    sleep_interrupted = 0;
+    if (nanosleep(&ts, NULL) == -1 && errno == EINTR) {
+        sleep_interrupted = 1;
+    }
+    return sleep_interrupted;
+}
+
+extern int32_t _sleep_interrupted() {
+    return sleep_interrupted;
+}
+
+extern int32_t _crash(int32_t v) {
+    volatile int32_t *ptr = NULL;
+    *ptr = v;
+    return v;
+}
+
+extern int64_t _sum_int32(int32_t *values, int32_t count) {
+    int64_t sum = 0;
+    for (int32_t i = 0; i < count; i++) {
+        sum += values[i];
+    }
+    return sum;
+}
+
+static int32_t test_allocations = 0;
+
+extern void *_test_malloc(size_t size) {
+    test_allocations++;
+    return malloc(size);
+}
+
+extern void _test_free(void *ptr) {
+    test_allocations--;
+    free(ptr);
+}
+
+extern int32_t _test_allocations() {
+    return test_allocations;
+}
+
+extern void _increment_int32(int32_t *value) {
+    (*value)++;
+}
+
+struct labeled {
+    const char *label;
+    int32_t length;
+};
+
+extern int32_t _measure_label(struct labeled *l) {
+    l->length = (int32_t) strlen(l->label);
+    return l->length;
+}
+
+extern int32_t _is_null(void *ptr) {
+    return ptr == NULL;
+}
+
+struct config {
+    const char *name;
+    struct point origin;
+    int32_t *values;
+    int32_t count;
+    struct point *offset;
+    int64_t total;
+};
+
+extern int64_t _apply_config(struct config *c) {
+    c->total = (int64_t) strlen(c->name) + c->origin.x + c->offset->x;
+    for (int32_t i = 0; i < c->count; i++) {
+        c->total += c->values[i];
+    }
+    c->values[0] = 7;
+    c->offset->y = 99;
+    return c->total;
+}
+
+extern int32_t _config_name_length(struct config c) {
+    return (int32_t) strlen(c.name) + c.count;
+}
+
+struct node {
+    const char *label;
+    int32_t length;
+    struct node *next;
+};
+
+extern int32_t _measure_nodes(struct node *n) {
+    int32_t total = 0;
+    for (; n != NULL; n = n->next) {
+        n->length = (int32_t) strlen(n->label);
+        total += n->length;
+    }
+    return total;
+}
+
+extern struct labeled _static_label() {
+    struct labeled l = { "static", 6 };
+    return l;
+}
+
+extern int32_t _count_strings(char **values) {
+    if (values == NULL) {
+        return -1;
+    }
+    int32_t count = 0;
+    for (; values[count] != NULL; count++);
+    return count;
+}
+
+struct word_lists {
+    char **first;
+    char **second;
+};
+
+extern int32_t _count_word_lists(struct word_lists *lists) {
+    return _count_strings(lists->first) * 100 + _count_strings(lists->second);
+}
+
+extern char **_split_words(const char *text) {
+    size_t count = 1;
+    for (const char *c = text; *c != '\0'; c++) {
+        if (*c == ' ') {
+            count++;
+        }
+    }
+
+    char **words = calloc(count + 1, sizeof(char *));
+    const char *start = text;
+    for (size_t i = 0; i < count; i++) {
+        const char *end = strchr(start, ' ');
+        size_t length = end != NULL ? (size_t) (end - start) : strlen(start);
+        words[i] = strndup(start, length);
+        start += length + 1;
+    }
+    return words;
+}
+
+static char *static_words[] = { "alpha", "beta", NULL };
+
+extern char **_static_words() {
+    return static_words;
+}
+
+extern char **_block_words(int32_t count) {
+    char **block = malloc(count * (sizeof(char *) + 8));
+    char *strings = (char *) (block + count);
+    for (int32_t i = 0; i < count; i++) {
+        block[i] = strings + i * 8;
+        snprintf(block[i], 8, "word%d", i);
+    }
+    return block;
+}
+
+extern int32_t _wide_length(const wchar_t *s) {
+    return (int32_t) wcslen(s);
+}
+
+extern wchar_t *_wide_upper(const wchar_t *s) {
+    size_t length = wcslen(s);
+    wchar_t *r = malloc((length + 1) * sizeof(wchar_t));
+    for (size_t i = 0; i <= length; i++) {
+        r[i] = s[i] >= L'a' && s[i] <= L'z' ? s[i] - L'a' + L'A' : s[i];
+    }
+    return r;
+}
+
+extern int32_t _utf16_units(const uint16_t *s) {
+    int32_t count = 0;
+    for (; s[count] != 0; count++);
+    return count;
+}
+
+extern uint16_t *_utf16_dup(const uint16_t *s) {
+    int32_t count = _utf16_units(s);
+    uint16_t *r = malloc((count + 1) * sizeof(uint16_t));
+    memcpy(r, s, (count + 1) * sizeof(uint16_t));
+    return r;
+}
+
+extern uint32_t _utf32_at(const uint32_t *s, int32_t index) {
+    return s[index];
+}
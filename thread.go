@@ -0,0 +1,151 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+/*
+#include <pthread.h>
+*/
+import "C"
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// ErrThreadClosed is returned when a call is dispatched to a Thread, which
+// was already closed.
+var ErrThreadClosed = errors.New("thread is already closed")
+
+// Thread is a dedicated, locked OS thread. Native calls bound to a Thread (see
+// OnThread and Library.SetThread) are always executed on this OS thread, in
+// the order they were dispatched, which is required by C libraries relying on
+// thread-local state or thread affinity.
+// The same Thread can be shared by multiple libraries, e.g. when a library
+// expects its dependencies to be called from the same thread.
+// Calls dispatched while already running on the Thread, e.g. from inside
+// of Run, are executed directly.
+type Thread struct {
+	m      sync.RWMutex
+	calls  chan func()
+	closed bool
+	id     C.pthread_t
+}
+
+// NewThread starts a new dedicated OS thread.
+func NewThread() *Thread {
+	t := &Thread{calls: make(chan func(), 64)}
+	started := make(chan struct{})
+	go t.loop(started)
+	<-started
+	return t
+}
+
+// Run executes the given function on the thread and waits for it to return.
+// A panic raised by the function is propagated to the caller. Run can be used
+// to execute initialization code, which must run on the same thread as the
+// native calls.
+func (t *Thread) Run(fn func()) error {
+	// the worker goroutine is locked to the thread, no other goroutine
+	// can run on it
+	if C.pthread_equal(C.pthread_self(), t.id) != 0 {
+		fn()
+		return nil
+	}
+
+	done := make(chan interface{}, 1)
+
+	t.m.RLock()
+	if t.closed {
+		t.m.RUnlock()
+		return ErrThreadClosed
+	}
+	t.calls <- func() {
+		defer func() {
+			done <- recover()
+		}()
+		fn()
+	}
+	t.m.RUnlock()
+
+	if p := <-done; p != nil {
+		panic(p)
+	}
+	return nil
+}
+
+// Close stops the thread after all already dispatched calls are executed.
+// Calls dispatched after closing fail with ErrThreadClosed.
+func (t *Thread) Close() error {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.closed {
+		return ErrThreadClosed
+	}
+	t.closed = true
+	close(t.calls)
+	return nil
+}
+
+func (t *Thread) loop(started chan struct{}) {
+	// the thread is never unlocked, to terminate it when the loop ends,
+	// instead of handing a thread with modified native state back to Go
+	runtime.LockOSThread()
+	t.id = C.pthread_self()
+	close(started)
+
+	for fn := range t.calls {
+		fn()
+	}
+}
+
+// OnThread executes all calls to the imported function on the given Thread,
+// independent of the Thread set on the Library.
+func OnThread(thread *Thread) ImportOption {
+	return func(config *importConfig) {
+		config.thread = thread
+	}
+}
+
+// SetThread binds all functions imported from the Library to the given Thread,
+// including already imported functions. Passing nil removes the binding, and
+// calls are executed on the calling goroutine's thread again.
+func (l *Library) SetThread(thread *Thread) {
+	l.thread.Store(thread)
+}
+
+func (l *Library) threadStub(sig *signature, call callFunc, config *importConfig) callFunc {
+	return func(ctx context.Context, values []reflect.Value) []reflect.Value {
+		thread := config.thread
+		if thread == nil {
+			thread = l.thread.Load().(*Thread)
+		}
+		if thread == nil {
+			return call(ctx, values)
+		}
+
+		var results []reflect.Value
+		if err := thread.Run(func() {
+			results = call(ctx, values)
+		}); err != nil {
+			return sig.fail(err)
+		}
+		return results
+	}
+}
@@ -0,0 +1,125 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestThreadAffinity(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	thread := NewThread()
+	defer thread.Close()
+
+	var threadId func() uint64
+	if err := l.Import("_thread_id", &threadId, OnThread(thread)); err != nil {
+		t.Errorf("Symbol _thread_id failed to be imported: %v", err)
+		return
+	}
+
+	var expected uint64
+	thread.Run(func() {
+		expected = threadId()
+	})
+
+	var wg sync.WaitGroup
+	ids := make([]uint64, 32)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids[i] = threadId()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, id := range ids {
+		if id != expected {
+			t.Errorf("call %d executed on thread %x, expected %x", i, id, expected)
+		}
+	}
+}
+
+func TestLibraryThread(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var threadId func() (uint64, error)
+	if err := l.Import("_thread_id", &threadId); err != nil {
+		t.Errorf("Symbol _thread_id failed to be imported: %v", err)
+		return
+	}
+
+	thread := NewThread()
+	l.SetThread(thread)
+
+	first, _ := threadId()
+	for i := 0; i < 10; i++ {
+		if id, _ := threadId(); id != first {
+			t.Errorf("call %d executed on thread %x, expected %x", i, id, first)
+		}
+	}
+
+	thread.Close()
+	if _, err := threadId(); err != ErrThreadClosed {
+		t.Errorf("expected ErrThreadClosed, got %v", err)
+	}
+
+	l.SetThread(nil)
+	if _, err := threadId(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestThreadPanic(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	thread := NewThread()
+	defer thread.Close()
+
+	var get func(*Handle) int32
+	if err := l.Import("_handle_get", &get, OnThread(thread)); err != nil {
+		t.Errorf("Symbol _handle_get failed to be imported: %v", err)
+		return
+	}
+
+	h := NewHandle(0, nil)
+	h.Close()
+
+	defer func() {
+		if r := recover(); r != ErrHandleClosed {
+			t.Errorf("expected ErrHandleClosed panic, got %v", r)
+		}
+	}()
+	get(h)
+}
@@ -23,6 +23,10 @@ package libgoffi
 #include <stdint.h>
 
 typedef void* _ptr;
+
+static void *_uintptrToPointer(uintptr_t ptr) {
+	return (void *)ptr;
+}
 */
 import "C"
 import (
@@ -107,6 +111,10 @@ var (
 	// translated into a _Bool / bool (or similar) type in C.
 	TypeBool = reflect.TypeOf(true)
 
+	// TypeString represents a Go string. This type is
+	// translated into a char* type in C.
+	TypeString = reflect.TypeOf("")
+
 	// TypeUintptr represents a Go uintptr. This type is
 	// translated into a intptr_t (or similar) type in C.
 	TypeUintptr = reflect.TypeOf(uintptr(0))
@@ -121,7 +129,6 @@ var (
 	TypeVoid = reflect.TypeOf(&struct{}{})
 )
 
-var valueNil = reflect.ValueOf(nil)
 var valueNilError = reflect.Zero(TypeError)
 
 func wrapType(t reflect.Type) ffiType {
@@ -130,16 +137,28 @@ func wrapType(t reflect.Type) ffiType {
 	// - slices (maybe only pointer slices?)
 	// - func
 	// - interface
-	// - array
-	// - struct
 	// - complex64
 	// - complex128
 	// - chan
 
+	if def := lookupEnum(t); def != nil {
+		return def.ffi
+	}
+
 	switch t.Kind() {
+	case reflect.Struct, reflect.Array:
+		if ct := lookupCTypeByRepr(t); ct != nil {
+			return ct.ffi
+		}
+		ct, err := CTypeOf(t)
+		if err != nil {
+			panic(err)
+		}
+		return ct.ffi
+
 	case reflect.String:
 		fallthrough
-	case reflect.Ptr:
+	case reflect.Ptr, reflect.Slice:
 		fallthrough
 	case reflect.UnsafePointer:
 		fallthrough
@@ -216,92 +235,95 @@ func unwrapType(t ffiType) reflect.Type {
 	case typePointer:
 		return TypeUintptr
 	}
+
+	if ct := lookupCTypeByFfi(t); ct != nil {
+		return ct.goType
+	}
 	panic(fmt.Errorf("unhandled data type: %d", t))
 }
 
-type finalizer = func()
-
-func wrapValue(value reflect.Value) (unsafe.Pointer, finalizer) {
+func wrapValue(a *arena, value reflect.Value) unsafe.Pointer {
 	t := value.Type()
 	v := value.Interface()
 	switch t.Kind() {
 	case reflect.String:
-		cs := C.CString(v.(string))
-		fin := func() {
-			C.free(unsafe.Pointer(cs))
-		}
-		return unsafe.Pointer(&cs), fin
+		cs := a.cstring(v.(string))
+		return unsafe.Pointer(&cs)
 
 	case reflect.UnsafePointer:
-		return v.(unsafe.Pointer), nil
+		ptr := v.(unsafe.Pointer)
+		return unsafe.Pointer(&ptr)
 	case reflect.Uintptr:
-		return unsafe.Pointer(v.(uintptr)), nil
+		ptr := C.uintptr_t(value.Uint())
+		return unsafe.Pointer(&ptr)
 
 	case reflect.Uint:
 		val := value.Uint()
 		if intSize == 2 {
 			v := C.uint16_t(val)
-			return unsafe.Pointer(&v), nil
+			return unsafe.Pointer(&v)
 		}
 		v := C.uint32_t(val)
-		return unsafe.Pointer(&v), nil
+		return unsafe.Pointer(&v)
 
 	case reflect.Uint8:
 		val := C.uint8_t(value.Uint())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
 	case reflect.Uint16:
 		val := C.uint16_t(value.Uint())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
 	case reflect.Uint32:
 		val := C.uint32_t(value.Uint())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
 	case reflect.Uint64:
 		val := C.uint64_t(value.Uint())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
 	case reflect.Int:
 		val := value.Int()
 		if intSize == 2 {
 			v := C.int16_t(val)
-			return unsafe.Pointer(&v), nil
+			return unsafe.Pointer(&v)
 		}
 		v := C.int32_t(val)
-		return unsafe.Pointer(&v), nil
+		return unsafe.Pointer(&v)
 
 	case reflect.Int8:
 		val := C.int8_t(value.Int())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
 	case reflect.Int16:
 		val := C.int16_t(value.Int())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
 	case reflect.Int32:
 		val := C.int32_t(value.Int())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
 	case reflect.Int64:
 		val := C.int64_t(value.Int())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
 	case reflect.Float32:
 		val := C.float(value.Float())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
 	case reflect.Float64:
 		val := C.double(value.Float())
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 
-	case reflect.Ptr:
-		ptr := (*C.int)(C.malloc(C.size_t(ptrSize)))
-		*ptr = (C.int)(value.Pointer())
-		fin := func() {
-			C.free(unsafe.Pointer(ptr))
-		}
-		return unsafe.Pointer(&ptr), fin
+	case reflect.Ptr, reflect.Slice:
+		holder := (*unsafe.Pointer)(a.alloc(uintptr(ptrSize)))
+		*holder = value.UnsafePointer()
+		return unsafe.Pointer(holder)
+
+	case reflect.Struct, reflect.Array:
+		ptr := reflect.New(t)
+		ptr.Elem().Set(value)
+		return ptr.UnsafePointer()
 
 	case reflect.Bool:
 		b := 0
@@ -311,10 +333,10 @@ func wrapValue(value reflect.Value) (unsafe.Pointer, finalizer) {
 
 		if boolSize == 1 {
 			val := C.int8_t(b)
-			return unsafe.Pointer(&val), nil
+			return unsafe.Pointer(&val)
 		}
 		val := C.int16_t(b)
-		return unsafe.Pointer(&val), nil
+		return unsafe.Pointer(&val)
 	}
 	panic(fmt.Errorf("unhandled data type: %s", t.Kind().String()))
 }
@@ -336,9 +358,20 @@ func convertValue(value reflect.Value, t reflect.Type) reflect.Value {
 
 	switch t.Kind() {
 	case reflect.Uintptr:
-		fallthrough
+		switch vt.Kind() {
+		case reflect.UnsafePointer:
+			value = reflect.ValueOf(value.Pointer()).Convert(t)
+		default:
+			value = value.Convert(t)
+		}
 	case reflect.UnsafePointer:
-		fallthrough
+		switch vt.Kind() {
+		case reflect.Uintptr:
+			ptr := C._uintptrToPointer(C.uintptr_t(value.Uint()))
+			value = reflect.ValueOf(ptr).Convert(t)
+		default:
+			value = value.Convert(t)
+		}
 	case reflect.Ptr:
 		it := t.Elem()
 		reflect.ValueOf(value.Convert(it).Interface())
@@ -358,6 +391,8 @@ func convertValue(value reflect.Value, t reflect.Type) reflect.Value {
 			b = true
 		}
 		value = reflect.ValueOf(b)
+	case reflect.Struct, reflect.Array:
+		value = convertAggregate(value, t)
 	default:
 		value = reflect.ValueOf(value.Convert(t).Interface())
 	}
@@ -0,0 +1,339 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"debug/dwarf"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// ErrNoDebugInfo is returned when verifying a signature (see VerifySignature)
+// of a library without any DWARF debug information.
+var ErrNoDebugInfo = errors.New("no DWARF debug information found")
+
+var errSymbolNoDebugInfo = errors.New("symbol not found in DWARF debug information")
+
+// SignatureMismatchError is returned when the C function type of an import
+// doesn't match the function signature declared in the debug information
+// of the library.
+type SignatureMismatchError struct {
+	// Symbol is the name of the imported function.
+	Symbol string
+
+	// Parameter is the index of the mismatching C parameter, or -1 if
+	// the return type or the number of parameters doesn't match.
+	Parameter int
+
+	// Declared is the C type declared in the debug information.
+	Declared string
+
+	// Requested is the type requested by the import.
+	Requested string
+}
+
+func (e *SignatureMismatchError) Error() string {
+	if e.Parameter < 0 {
+		return fmt.Sprintf("signature mismatch for %s: declared %s, requested %s",
+			e.Symbol, e.Declared, e.Requested)
+	}
+	return fmt.Sprintf("signature mismatch for %s, parameter %d: declared %s, requested %s",
+		e.Symbol, e.Parameter, e.Declared, e.Requested)
+}
+
+// VerifySignature verifies the C function type of the import against the
+// function declaration found in the DWARF debug information of the library.
+// Debug information is read from the library file itself, or from a separate
+// debug file found by build-id or debug link (on Linux), or a dSYM bundle next
+// to the library (on Darwin).
+// Parameters and the return type are compared by their kind (integer, floating
+// point, pointer, aggregate) and size. If the library has no debug information,
+// the import fails with ErrNoDebugInfo.
+func VerifySignature() ImportOption {
+	return func(config *importConfig) {
+		config.verify = true
+	}
+}
+
+// DebugInfoFile verifies the signature of the import, like VerifySignature,
+// using the DWARF debug information from the given separate debug file.
+func DebugInfoFile(path string) ImportOption {
+	return func(config *importConfig) {
+		config.verify = true
+		config.debugFile = path
+	}
+}
+
+type debugInfo struct {
+	data      *dwarf.Data
+	functions map[string]dwarf.Offset
+}
+
+type debugParam struct {
+	kind CKind
+	size int64
+	name string
+}
+
+func (l *Library) verifySignature(symbol string, cFnType reflect.Type, config *importConfig) error {
+	if !config.verify {
+		return nil
+	}
+
+	info, err := l.debugInfo(config.debugFile)
+	if err != nil {
+		return err
+	}
+
+	ret, params, variadic, err := info.function(symbol)
+	if err != nil {
+		return err
+	}
+
+	if cFnType.NumIn() < len(params) || (cFnType.NumIn() > len(params) && !variadic) {
+		return &SignatureMismatchError{
+			Symbol:    symbol,
+			Parameter: -1,
+			Declared:  strconv.Itoa(len(params)) + " parameters",
+			Requested: strconv.Itoa(cFnType.NumIn()) + " parameters",
+		}
+	}
+
+	for i, param := range params {
+		if !param.matches(cFnType.In(i)) {
+			return &SignatureMismatchError{
+				Symbol:    symbol,
+				Parameter: i,
+				Declared:  param.name,
+				Requested: cFnType.In(i).String(),
+			}
+		}
+	}
+
+	// ignoring a returned value is fine, the other way round is not
+	if cFnType.NumOut() > 0 && !ret.matches(cFnType.Out(0)) {
+		return &SignatureMismatchError{
+			Symbol:    symbol,
+			Parameter: -1,
+			Declared:  "return type " + ret.name,
+			Requested: "return type " + cFnType.Out(0).String(),
+		}
+	}
+	return nil
+}
+
+func (l *Library) debugInfo(debugFile string) (*debugInfo, error) {
+	path := debugFile
+	if path == "" {
+		path = l.name
+		if info, err := l.Info(); err == nil {
+			path = info.Path
+		}
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	if info := l.debugInfos[path]; info != nil {
+		return info, nil
+	}
+
+	var data *dwarf.Data
+	var err error
+	if debugFile != "" {
+		data, err = readDebugFile(path)
+	} else {
+		data, err = loadDebugInfo(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := newDebugInfo(data)
+	if err != nil {
+		return nil, err
+	}
+	l.debugInfos[path] = info
+	return info, nil
+}
+
+func newDebugInfo(data *dwarf.Data) (*debugInfo, error) {
+	info := &debugInfo{data: data, functions: make(map[string]dwarf.Offset)}
+
+	r := data.Reader()
+	for {
+		entry, err := r.Next()
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return info, nil
+		}
+
+		if entry.Tag != dwarf.TagSubprogram {
+			continue
+		}
+
+		name, _ := entry.Val(dwarf.AttrName).(string)
+		if name == "" {
+			continue
+		}
+
+		// prefer definitions over declarations
+		_, declared := info.functions[name]
+		if decl, _ := entry.Val(dwarf.AttrDeclaration).(bool); !declared || !decl {
+			info.functions[name] = entry.Offset
+		}
+	}
+}
+
+func (d *debugInfo) function(symbol string) (debugParam, []debugParam, bool, error) {
+	offset, ok := d.functions[symbol]
+	if !ok {
+		return debugParam{}, nil, false, fmt.Errorf("%w: %s", errSymbolNoDebugInfo, symbol)
+	}
+
+	r := d.data.Reader()
+	r.Seek(offset)
+	entry, err := r.Next()
+	if err != nil {
+		return debugParam{}, nil, false, err
+	}
+
+	ret, err := d.param(entry)
+	if err != nil {
+		return debugParam{}, nil, false, err
+	}
+
+	params := make([]debugParam, 0)
+	variadic := false
+	for entry.Children {
+		child, err := r.Next()
+		if err != nil {
+			return debugParam{}, nil, false, err
+		}
+		if child == nil || child.Tag == 0 {
+			break
+		}
+
+		switch child.Tag {
+		case dwarf.TagFormalParameter:
+			param, err := d.param(child)
+			if err != nil {
+				return debugParam{}, nil, false, err
+			}
+			params = append(params, param)
+		case dwarf.TagUnspecifiedParameters:
+			variadic = true
+		}
+
+		if child.Children {
+			r.SkipChildren()
+		}
+	}
+	return ret, params, variadic, nil
+}
+
+func (d *debugInfo) param(entry *dwarf.Entry) (debugParam, error) {
+	offset, ok := entry.Val(dwarf.AttrType).(dwarf.Offset)
+	if !ok {
+		return debugParam{kind: CKindVoid, name: "void"}, nil
+	}
+
+	t, err := d.data.Type(offset)
+	if err != nil {
+		return debugParam{}, err
+	}
+	return newDebugParam(t), nil
+}
+
+func newDebugParam(t dwarf.Type) debugParam {
+	name := t.String()
+	for {
+		switch tt := t.(type) {
+		case *dwarf.TypedefType:
+			t = tt.Type
+			continue
+		case *dwarf.QualType:
+			t = tt.Type
+			continue
+		}
+		break
+	}
+
+	param := debugParam{size: t.Size(), name: name}
+	switch tt := t.(type) {
+	case *dwarf.VoidType:
+		param.kind = CKindVoid
+	case *dwarf.IntType, *dwarf.CharType, *dwarf.EnumType:
+		param.kind = CKindSigned
+	case *dwarf.UintType, *dwarf.UcharType:
+		param.kind = CKindUnsigned
+	case *dwarf.BoolType:
+		param.kind = CKindBool
+	case *dwarf.FloatType:
+		param.kind = CKindFloat
+	case *dwarf.PtrType, *dwarf.FuncType:
+		param.kind = CKindPointer
+	case *dwarf.StructType:
+		param.kind = CKindStruct
+		if tt.Kind == "union" {
+			param.kind = CKindUnion
+		}
+	case *dwarf.ArrayType:
+		param.kind = CKindArray
+	default:
+		param.kind = CKindVoid
+		param.size = -1
+	}
+	return param
+}
+
+func (p debugParam) matches(t reflect.Type) bool {
+	ct := lookupCTypeByRepr(t)
+	if ct == nil {
+		var err error
+		if ct, err = CTypeOf(t); err != nil {
+			return false
+		}
+	}
+
+	if p.size != int64(ct.size) {
+		return false
+	}
+	return p.class() == classOf(ct.kind)
+}
+
+func (p debugParam) class() CKind {
+	// pointers are frequently passed as uintptr
+	if p.kind == CKindPointer {
+		return CKindUnsigned
+	}
+	return classOf(p.kind)
+}
+
+func classOf(kind CKind) CKind {
+	switch kind {
+	case CKindSigned, CKindUnsigned, CKindBool, CKindPointer, CKindString:
+		return CKindUnsigned
+	case CKindUnion:
+		return CKindStruct
+	}
+	return kind
+}
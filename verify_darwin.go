@@ -0,0 +1,51 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"debug/dwarf"
+	"debug/macho"
+	"os"
+	"path/filepath"
+)
+
+// loadDebugInfo reads the DWARF debug information of the given library,
+// or of a dSYM bundle next to it
+func loadDebugInfo(path string) (*dwarf.Data, error) {
+	if data, err := readDebugFile(path); err == nil {
+		return data, nil
+	}
+
+	dsym := filepath.Join(path+".dSYM", "Contents", "Resources", "DWARF", filepath.Base(path))
+	if _, err := os.Stat(dsym); err == nil {
+		return readDebugFile(dsym)
+	}
+	return nil, ErrNoDebugInfo
+}
+
+func readDebugFile(path string) (*dwarf.Data, error) {
+	f, err := macho.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if f.Section("__debug_info") == nil {
+		return nil, ErrNoDebugInfo
+	}
+	return f.DWARF()
+}
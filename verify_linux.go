@@ -0,0 +1,99 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var debugDirectory = "/usr/lib/debug"
+
+// loadDebugInfo reads the DWARF debug information of the given library,
+// following the build-id and debug link of stripped libraries to their
+// separate debug files
+func loadDebugInfo(path string) (*dwarf.Data, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if f.Section(".debug_info") != nil {
+		return f.DWARF()
+	}
+
+	for _, candidate := range debugFileCandidates(f, path) {
+		if _, err := os.Stat(candidate); err == nil {
+			return readDebugFile(candidate)
+		}
+	}
+	return nil, ErrNoDebugInfo
+}
+
+func readDebugFile(path string) (*dwarf.Data, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if f.Section(".debug_info") == nil {
+		return nil, ErrNoDebugInfo
+	}
+	return f.DWARF()
+}
+
+func debugFileCandidates(f *elf.File, path string) []string {
+	candidates := make([]string, 0)
+
+	// NT_GNU_BUILD_ID note: namesz, descsz, type, "GNU\x00", build-id
+	if section := f.Section(".note.gnu.build-id"); section != nil {
+		if note, err := section.Data(); err == nil && len(note) > 16 {
+			id := hex.EncodeToString(note[16:])
+			candidates = append(candidates, filepath.Join(debugDirectory, ".build-id", id[:2], id[2:]+".debug"))
+		}
+	}
+
+	// .gnu_debuglink: null-terminated file name, followed by a crc32
+	if section := f.Section(".gnu_debuglink"); section != nil {
+		if link, err := section.Data(); err == nil {
+			name := string(link)
+			if i := strings.IndexByte(name, 0); i >= 0 {
+				name = name[:i]
+			}
+
+			if name != "" {
+				if resolved, err := filepath.EvalSymlinks(path); err == nil {
+					path = resolved
+				}
+
+				dir := filepath.Dir(path)
+				candidates = append(candidates,
+					filepath.Join(dir, name),
+					filepath.Join(dir, ".debug", name),
+					filepath.Join(debugDirectory, dir, name),
+				)
+			}
+		}
+	}
+	return candidates
+}
@@ -0,0 +1,92 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package libgoffi
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var sqrt func(float64) float64
+	err = l.Import("_sqrt", &sqrt, VerifySignature())
+	if errors.Is(err, ErrNoDebugInfo) {
+		t.Skip("test library built without debug information")
+	}
+	if err != nil {
+		t.Errorf("Symbol _sqrt failed to be verified: %v", err)
+		return
+	}
+
+	var div func(int32, int32) (int32, int32, error)
+	if err := l.Import("_div", &div, OutParams(), VerifySignature()); err != nil {
+		t.Errorf("Symbol _div failed to be verified: %v", err)
+	}
+
+	var add func(point, point) point
+	if err := l.Import("_point_add", &add, VerifySignature()); err != nil {
+		t.Errorf("Symbol _point_add failed to be verified: %v", err)
+	}
+
+	// ignoring the return value is fine
+	var sint32 func(int32)
+	if err := l.Import("__sint32", &sint32, VerifySignature()); err != nil {
+		t.Errorf("Symbol __sint32 failed to be verified: %v", err)
+	}
+}
+
+func TestVerifySignatureMismatch(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var sqrt func(float32) float64
+	err = l.Import("_sqrt", &sqrt, VerifySignature())
+	if errors.Is(err, ErrNoDebugInfo) {
+		t.Skip("test library built without debug information")
+	}
+
+	var mismatch *SignatureMismatchError
+	if !errors.As(err, &mismatch) || mismatch.Parameter != 0 || mismatch.Declared != "double" {
+		t.Errorf("expected parameter mismatch, got %v", err)
+	}
+
+	var sint32 func(int64) int32
+	if err := l.Import("__sint32", &sint32, VerifySignature()); !errors.As(err, &mismatch) {
+		t.Errorf("expected size mismatch, got %v", err)
+	}
+
+	var div func(int32, int32) int32
+	if err := l.Import("_div", &div, VerifySignature()); !errors.As(err, &mismatch) || mismatch.Parameter != -1 {
+		t.Errorf("expected parameter count mismatch, got %v", err)
+	}
+
+	var add func(point, point) int64
+	if err := l.Import("_point_add", &add, VerifySignature()); !errors.As(err, &mismatch) {
+		t.Errorf("expected return type mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+/*
+#include <stdlib.h>
+#include <stdint.h>
+#include <wchar.h>
+
+const int _wcharSize = sizeof(wchar_t);
+
+static void *_stringPointer(uintptr_t ptr) {
+	return (void *)ptr;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"reflect"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// StringMode defines the C representation of a Go string.
+type StringMode int
+
+const (
+	// StringUTF8 maps Go strings to NUL terminated char* strings (default).
+	StringUTF8 StringMode = iota
+
+	// StringWide maps Go strings to NUL terminated wchar_t* strings, encoded
+	// as UTF-16 or UTF-32, depending on the size of wchar_t on the platform.
+	StringWide
+
+	// StringUTF16 maps Go strings to NUL terminated char16_t* strings.
+	StringUTF16
+
+	// StringUTF32 maps Go strings to NUL terminated char32_t* strings.
+	StringUTF32
+)
+
+// String returns the name of the C string type.
+func (m StringMode) String() string {
+	switch m {
+	case StringWide:
+		return "wchar_t*"
+	case StringUTF16:
+		return "char16_t*"
+	case StringUTF32:
+		return "char32_t*"
+	}
+	return "char*"
+}
+
+// WideString is a Go string, which is translated into a wchar_t* string in C.
+type WideString string
+
+// UTF16String is a Go string, which is translated into a char16_t* string in C.
+type UTF16String string
+
+// UTF32String is a Go string, which is translated into a char32_t* string in C.
+type UTF32String string
+
+var (
+	// TypeWideString represents a goffi.WideString (see StringWide).
+	TypeWideString = reflect.TypeOf(WideString(""))
+
+	// TypeUTF16String represents a goffi.UTF16String (see StringUTF16).
+	TypeUTF16String = reflect.TypeOf(UTF16String(""))
+
+	// TypeUTF32String represents a goffi.UTF32String (see StringUTF32).
+	TypeUTF32String = reflect.TypeOf(UTF32String(""))
+)
+
+var wcharSize = int(C._wcharSize)
+
+var errStringModeType = errors.New("string modes can only be applied to string parameters and results")
+
+// StringParam defines the C representation of the Go string parameter at the
+// given index. The index doesn't count a leading context or scope parameter.
+func StringParam(param int, mode StringMode) ImportOption {
+	return func(config *importConfig) {
+		if config.stringParams == nil {
+			config.stringParams = make(map[int]StringMode)
+		}
+		config.stringParams[param] = mode
+	}
+}
+
+// StringResult defines the C representation of the Go string result. Like
+// char* results, the returned string is freed after it was copied.
+func StringResult(mode StringMode) ImportOption {
+	return func(config *importConfig) {
+		config.stringResult = mode
+	}
+}
+
+// stringModeOf returns the string mode of marker types
+func stringModeOf(t reflect.Type) StringMode {
+	switch t {
+	case TypeWideString:
+		return StringWide
+	case TypeUTF16String:
+		return StringUTF16
+	case TypeUTF32String:
+		return StringUTF32
+	}
+	return StringUTF8
+}
+
+// newStringModes returns the string modes of the parameters and the result
+// of the given function type, defined by marker types or options
+func newStringModes(goFnType reflect.Type, returnsValue bool, config *importConfig) ([]StringMode, StringMode, error) {
+	var params []StringMode
+	for i := 0; i < goFnType.NumIn(); i++ {
+		mode, ok := config.stringParams[i]
+		if !ok {
+			mode = stringModeOf(goFnType.In(i))
+		} else if goFnType.In(i).Kind() != reflect.String {
+			return nil, StringUTF8, errStringModeType
+		}
+
+		if mode != StringUTF8 {
+			if params == nil {
+				params = make([]StringMode, goFnType.NumIn())
+			}
+			params[i] = mode
+		}
+	}
+	for i := range config.stringParams {
+		if i < 0 || i >= goFnType.NumIn() {
+			return nil, StringUTF8, errStringModeType
+		}
+	}
+
+	result := config.stringResult
+	if returnsValue {
+		if result != StringUTF8 && goFnType.Out(0).Kind() != reflect.String {
+			return nil, StringUTF8, errStringModeType
+		}
+		if result == StringUTF8 {
+			result = stringModeOf(goFnType.Out(0))
+		}
+	} else if result != StringUTF8 {
+		return nil, StringUTF8, errStringModeType
+	}
+	return params, result, nil
+}
+
+// unitSize returns the size of a code unit in bytes
+func (m StringMode) unitSize() int {
+	switch m {
+	case StringWide:
+		return wcharSize
+	case StringUTF16:
+		return 2
+	case StringUTF32:
+		return 4
+	}
+	return 1
+}
+
+// wstring encodes the string into a NUL terminated string of the given mode
+func (a *arena) wstring(s string, mode StringMode) unsafe.Pointer {
+	if mode == StringUTF8 {
+		return a.cstring(s)
+	}
+
+	runes := []rune(s)
+	if mode.unitSize() == 2 {
+		units := utf16.Encode(runes)
+		ptr := a.alloc(uintptr(2 * (len(units) + 1)))
+		buf := unsafe.Slice((*uint16)(ptr), len(units)+1)
+		copy(buf, units)
+		buf[len(units)] = 0
+		return ptr
+	}
+
+	ptr := a.alloc(uintptr(4 * (len(runes) + 1)))
+	buf := unsafe.Slice((*uint32)(ptr), len(runes)+1)
+	for i, r := range runes {
+		buf[i] = uint32(r)
+	}
+	buf[len(runes)] = 0
+	return ptr
+}
+
+// wrapString passes the string in the given mode
+func wrapString(a *arena, value reflect.Value, mode StringMode) unsafe.Pointer {
+	holder := (*unsafe.Pointer)(a.alloc(uintptr(ptrSize)))
+	*holder = a.wstring(value.String(), mode)
+	return unsafe.Pointer(holder)
+}
+
+// readString copies and frees the returned string of the given mode
+func readString(value reflect.Value, mode StringMode, t reflect.Type) reflect.Value {
+	ptr := C._stringPointer(C.uintptr_t(value.Uint()))
+	if ptr == nil {
+		return reflect.Zero(t)
+	}
+	defer C.free(ptr)
+	return reflect.ValueOf(decodeString(ptr, mode)).Convert(t)
+}
+
+// decodeString decodes the NUL terminated string of the given mode at ptr
+func decodeString(ptr unsafe.Pointer, mode StringMode) string {
+	if mode.unitSize() == 2 {
+		var units []uint16
+		for i := uintptr(0); ; i += 2 {
+			unit := *(*uint16)(unsafe.Add(ptr, i))
+			if unit == 0 {
+				break
+			}
+			units = append(units, unit)
+		}
+		return string(utf16.Decode(units))
+	}
+
+	var runes []rune
+	for i := uintptr(0); ; i += 4 {
+		unit := *(*uint32)(unsafe.Add(ptr, i))
+		if unit == 0 {
+			break
+		}
+		runes = append(runes, rune(unit))
+	}
+	return string(runes)
+}
@@ -0,0 +1,109 @@
+/*
+ * libgoffi - libffi adapter library for Go
+ * Copyright 2019 clevabit GmbH
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ * http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package libgoffi
+
+import (
+	"testing"
+)
+
+func TestWideStringMarker(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var length func(WideString) int32
+	if err := l.Import("_wide_length", &length); err != nil {
+		t.Errorf("Symbol _wide_length failed to be imported: %v", err)
+		return
+	}
+
+	// a non-BMP character is encoded as a surrogate pair in UTF-16
+	expected := int32(2)
+	if wcharSize == 2 {
+		expected = 3
+	}
+	if n := length("a\U0001F600"); n != expected {
+		t.Errorf("expected %d, got %d", expected, n)
+	}
+
+	var upper func(WideString) WideString
+	if err := l.Import("_wide_upper", &upper); err != nil {
+		t.Errorf("Symbol _wide_upper failed to be imported: %v", err)
+		return
+	}
+	// only ASCII letters are converted, other characters must be preserved
+	if s := upper("grüße 😀"); s != "GRüßE 😀" {
+		t.Errorf("expected GRüßE 😀, got %s", s)
+	}
+}
+
+func TestUTF16String(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var units func(UTF16String) int32
+	if err := l.Import("_utf16_units", &units); err != nil {
+		t.Errorf("Symbol _utf16_units failed to be imported: %v", err)
+		return
+	}
+	if n := units("a\U0001F600"); n != 3 {
+		t.Errorf("expected 3, got %d", n)
+	}
+
+	var dup func(string) (string, error)
+	if err := l.Import("_utf16_dup", &dup, StringParam(0, StringUTF16), StringResult(StringUTF16)); err != nil {
+		t.Errorf("Symbol _utf16_dup failed to be imported: %v", err)
+		return
+	}
+	if s, err := dup("héllo \U0001F600"); err != nil || s != "héllo \U0001F600" {
+		t.Errorf("unexpected string: %s (%v)", s, err)
+	}
+}
+
+func TestUTF32String(t *testing.T) {
+	l, err := NewLibrary(testLibrary, BindNow)
+	if err != nil {
+		t.Errorf("Library failed to be initialized: %v", err)
+		return
+	}
+	defer l.Close()
+
+	var at func(string, int32) uint32
+	if err := l.Import("_utf32_at", &at, StringParam(0, StringUTF32)); err != nil {
+		t.Errorf("Symbol _utf32_at failed to be imported: %v", err)
+		return
+	}
+	if r := at("a\U0001F600", 1); r != 0x1F600 {
+		t.Errorf("expected U+1F600, got %U", r)
+	}
+	if r := at("a", 1); r != 0 {
+		t.Errorf("expected terminating zero, got %d", r)
+	}
+
+	var illegal func(int32, int32) uint32
+	if err := l.Import("_utf32_at", &illegal, StringParam(0, StringUTF32)); err != errStringModeType {
+		t.Errorf("expected errStringModeType, got %v", err)
+	}
+}